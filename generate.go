@@ -2,6 +2,7 @@ package main
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"os"
 	"strings"
@@ -22,16 +23,26 @@ func (cfg *gollamaConfig) generate() {
 
 	// Create a slice of ImageData to send to Ollama,
 	// expand the paths of the images (if needed) and read the image data
+	// every unreadable image is reported before exiting
 	imageData := []oapi.ImageData{}
+	imageErrs := []error{}
 	for _, img := range cfg.Images {
 		expandedPath, err := utils.ExpandPath(img)
 		if err != nil {
+			imageErrs = append(imageErrs, fmt.Errorf("could not read image %s: %w", img, err))
 			continue
 		}
 		imgData, err := os.ReadFile(expandedPath)
-		if err == nil {
-			imageData = append(imageData, imgData)
+		if err != nil {
+			// the error already contains the path
+			imageErrs = append(imageErrs, fmt.Errorf("could not read image: %w", err))
+			continue
 		}
+		imageData = append(imageData, imgData)
+	}
+
+	if err := errors.Join(imageErrs...); err != nil {
+		utils.PrintError(err, true)
 	}
 
 	chatRequest := oapi.GenerateRequest{
@@ -0,0 +1,83 @@
+package chat
+
+import (
+	"fmt"
+
+	"github.com/charmbracelet/bubbles/viewport"
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+)
+
+// The code (or tool arguments) shown for approval, it is wrapped rather than
+// truncated and scrolls once it is longer than execPreviewLines. Running it is
+// only accepted once the whole text was shown
+type approvalPreview struct {
+	viewport viewport.Model
+	text     string
+	width    int
+	seen     bool // the end of the text was shown
+}
+
+// Creates the preview of the text
+func newApprovalPreview(text string) *approvalPreview {
+	preview := &approvalPreview{
+		viewport: viewport.New(0, 0),
+		text:     text,
+	}
+	preview.viewport.KeyMap = viewport.KeyMap{
+		Up:           Keys.PreviousItem,
+		Down:         Keys.NextItem,
+		HalfPageUp:   Keys.HalfPageUp,
+		HalfPageDown: Keys.HalfPageDown,
+	}
+
+	return preview
+}
+
+// Wraps the text to the width (it is only wrapped again if the width changed)
+func (preview *approvalPreview) resize(width int) {
+	if width == preview.width {
+		return
+	}
+
+	preview.width = width
+	wrapped := lipgloss.NewStyle().Width(width).Render(preview.text)
+
+	preview.viewport.Width = width
+	preview.viewport.Height = min(execPreviewLines, lipgloss.Height(wrapped))
+	preview.viewport.SetContent(wrapped)
+	preview.seen = preview.seen || preview.viewport.AtBottom()
+}
+
+// Scrolls the preview
+func (preview *approvalPreview) update(msg tea.KeyMsg) {
+	preview.viewport, _ = preview.viewport.Update(msg)
+	preview.seen = preview.seen || preview.viewport.AtBottom()
+}
+
+// Renders the preview with a line telling how much of it is left to scroll
+func (preview *approvalPreview) view() string {
+	body := lipgloss.NewStyle().
+		Foreground(foregroundColor).
+		Border(lipgloss.NormalBorder(), false, false, false, true).
+		BorderForeground(mutedColor).
+		PaddingLeft(1).
+		Render(preview.viewport.View())
+
+	if preview.viewport.TotalLineCount() <= preview.viewport.Height {
+		return body
+	}
+
+	hidden := preview.viewport.TotalLineCount() - preview.viewport.YOffset - preview.viewport.Height
+	scroll := keyHint("scroll", Keys.PreviousItem, Keys.NextItem)
+	status := scroll + " • end of the text"
+	if hidden > 0 {
+		status = fmt.Sprintf("%s • %d more lines", scroll, hidden)
+	}
+
+	return lipgloss.JoinVertical(
+		lipgloss.Left,
+		body,
+		lipgloss.NewStyle().Foreground(mutedColor).Render(status),
+	)
+}
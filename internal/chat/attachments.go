@@ -0,0 +1,152 @@
+package chat
+
+import (
+	"fmt"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/charmbracelet/bubbles/key"
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+	"github.com/dustin/go-humanize"
+	zone "github.com/lrstanley/bubblezone"
+	"github.com/muesli/reflow/truncate"
+)
+
+// Returns the bubblezone ID of the attachment with the provided index
+func attachmentZoneID(index int) string {
+	return fmt.Sprintf("attachment-%d", index)
+}
+
+// Adds a file to the attachments of the next message
+func (chat *Chat) addAttachment(path string) tea.Cmd {
+	if slices.Contains(chat.attachments, path) {
+		return chat.notify(fmt.Sprintf("%s is already attached", filepath.Base(path)))
+	}
+
+	var warning tea.Cmd
+
+	if isImage(path) {
+		if !chat.isMultiModal {
+			return chat.notify(fmt.Sprintf("%s doesn't support images", chat.modelName))
+		}
+	} else {
+		file, err := readFileAttachment(path)
+		if err != nil {
+			return chat.notify(fmt.Sprintf("Could not attach the file: %v", err))
+		}
+
+		if file.Truncated {
+			warning = chat.notify(fmt.Sprintf(
+				"%s is larger than %s, only the beginning of the file will be sent",
+				file.Name(),
+				humanize.IBytes(maxFileAttachmentSize),
+			))
+		}
+
+		chat.attachedFiles[path] = file
+	}
+
+	chat.attachments = append(chat.attachments, path)
+	chat.traySelected = len(chat.attachments) - 1
+
+	return warning
+}
+
+// Removes the attachment with the provided index, the tray loses the focus
+// once it is empty
+func (chat *Chat) removeAttachment(index int) {
+	if index < 0 || index >= len(chat.attachments) {
+		return
+	}
+
+	delete(chat.attachedFiles, chat.attachments[index])
+	chat.attachments = slices.Delete(chat.attachments, index, index+1)
+	chat.traySelected = min(chat.traySelected, len(chat.attachments)-1)
+	chat.traySelected = max(chat.traySelected, 0)
+
+	if len(chat.attachments) == 0 {
+		chat.trayFocused = false
+	}
+}
+
+// Removes every attachment (e.g. once the message is sent)
+func (chat *Chat) clearAttachments() {
+	chat.attachments = nil
+	chat.attachedFiles = map[string]FileAttachment{}
+	chat.traySelected = 0
+	chat.trayFocused = false
+}
+
+// Handles the key presses while the attachment tray is focused
+func (chat *Chat) handleTrayKeys(msg tea.KeyMsg) tea.Cmd {
+	if key.Matches(msg, Keys.Close, Keys.RemoveAttachment) {
+		chat.trayFocused = false
+		return nil
+	}
+
+	switch {
+	case key.Matches(msg, Keys.PreviousAttachment):
+		chat.traySelected = max(chat.traySelected-1, 0)
+	case key.Matches(msg, Keys.NextAttachment):
+		chat.traySelected = min(chat.traySelected+1, len(chat.attachments)-1)
+	case key.Matches(msg, Keys.RemoveSelected):
+		chat.removeAttachment(chat.traySelected)
+	case key.Matches(msg, Keys.Submit):
+		chat.trayFocused = false
+	}
+
+	return nil
+}
+
+// Removes the attachment that was clicked, returns false if the click was not
+// on an attachment
+func (chat *Chat) handleTrayClick(msg tea.MouseMsg) bool {
+	for i := range chat.attachments {
+		if zone.Get(attachmentZoneID(i)).InBounds(msg) {
+			chat.removeAttachment(i)
+			return true
+		}
+	}
+
+	return false
+}
+
+// Renders the attachments of the next message above the prompt
+func (chat *Chat) attachmentTrayView() string {
+	if len(chat.attachments) == 0 {
+		return ""
+	}
+
+	chips := []string{}
+	for i, path := range chat.attachments {
+		style := HighlightStyle
+		if chat.trayFocused && i == chat.traySelected {
+			style = HighlightActiveStyle
+		}
+
+		icon := "󰈙"
+		if isImage(path) {
+			icon = "󰁦"
+		}
+
+		chip := style.Render(
+			fmt.Sprintf("%s %s ✕", icon, truncate.StringWithTail(filepath.Base(path), 24, "…")),
+		)
+		chips = append(chips, zone.Mark(attachmentZoneID(i), chip))
+	}
+
+	help := keyHintsView(keyHint("manage attachments", Keys.RemoveAttachment))
+	if chat.trayFocused {
+		help = keyHintsView(
+			keyHint("select", Keys.PreviousAttachment, Keys.NextAttachment),
+			keyHint("remove", Keys.RemoveSelected),
+			keyHint("done", Keys.Close),
+		)
+	}
+
+	return lipgloss.NewStyle().Width(chat.width).AlignHorizontal(lipgloss.Center).Render(
+		strings.Join(chips, " ") + help,
+	)
+}
@@ -3,32 +3,32 @@ package chat
 import (
 	"bytes"
 	"context"
-	"encoding/gob"
 	"errors"
 	"fmt"
 	"image"
-	"io"
 	"os"
-	"path/filepath"
 	"runtime"
+	"slices"
 	"strings"
 	"time"
 
 	_ "image/jpeg"
 	_ "image/png"
 
-	"github.com/adrg/xdg"
 	"github.com/atotto/clipboard"
 	"github.com/charmbracelet/bubbles/filepicker"
 	"github.com/charmbracelet/bubbles/help"
+	"github.com/charmbracelet/bubbles/key"
+	"github.com/charmbracelet/bubbles/textinput"
 	"github.com/charmbracelet/bubbles/viewport"
 	tea "github.com/charmbracelet/bubbletea"
-	"github.com/charmbracelet/glamour"
 	"github.com/charmbracelet/huh"
 	"github.com/charmbracelet/lipgloss"
 	"github.com/dustin/go-humanize"
 	"github.com/gaurav-gosain/gollama/internal/client"
+	"github.com/gaurav-gosain/gollama/internal/config"
 	"github.com/gaurav-gosain/gollama/internal/roles"
+	"github.com/gaurav-gosain/gollama/internal/tools"
 	"github.com/gaurav-gosain/gollama/internal/utils"
 	paintbrush "github.com/jordanella/go-ansi-paintbrush"
 	zone "github.com/lrstanley/bubblezone"
@@ -43,39 +43,18 @@ type (
 	FinishedStreaming bool
 )
 
-var (
-	purple = lipgloss.Color("#8839ef")
-	notif  = lipgloss.Color("#ff9900")
-	teal   = lipgloss.Color("#00baba")
-	cream  = lipgloss.Color("#FFFDF5")
-	gray   = lipgloss.Color("#aaaaaa")
-	black  = lipgloss.Color("#000000")
-)
-
-var HighlightStyle = lipgloss.NewStyle().
-	Background(purple).
-	Bold(true).
-	Padding(0, 1)
-
-var NotificationStyle = lipgloss.NewStyle().
-	Background(notif).
-	Foreground(black).
-	Bold(true).
-	Padding(0, 1)
-
-var HighlightActiveStyle = lipgloss.NewStyle().
-	Background(teal).
-	Foreground(black).
-	Bold(true).
-	Padding(0, 1)
-
-var HighlightForegroundStyle = lipgloss.NewStyle().
-	Foreground(purple).
-	Bold(true)
+// StreamError is sent when the request to Ollama fails
+type StreamError struct {
+	Err error
+}
 
-var DisabledHighlightStyle = lipgloss.NewStyle().
-	Foreground(gray).
-	Bold(true)
+// streamMsg tags a message of a streamed response (chunk, tool calls,
+// metrics, error or end) with the ID of its stream, the messages of a stopped
+// stream can still arrive once the next one started
+type streamMsg struct {
+	stream int
+	msg    tea.Msg
+}
 
 var RoundedBorder = lipgloss.NewStyle().
 	BorderStyle(lipgloss.RoundedBorder())
@@ -84,23 +63,32 @@ var layoutStyle = lipgloss.NewStyle().
 	Border(lipgloss.RoundedBorder()).
 	AlignVertical(lipgloss.Center)
 
-var helpStyle = lipgloss.NewStyle().Foreground(lipgloss.Color("241")).PaddingLeft(1).Render
-
 type ContentType string
 
 type ChatMessage struct {
-	CreatedAt time.Time
-	Role      string
-	Message   string
-	Images    []string
+	CreatedAt   time.Time
+	Role        string
+	Message     string
+	Images      []string
+	Interrupted bool   // true if the response was cancelled while streaming
+	Model       string // the model that wrote the (assistant) message
+	Metrics     *MessageMetrics
+	Files       []FileAttachment
+	Collapsed   bool   // only the first line of the message is shown
+	Summary     bool   // true if the message summarizes the conversation before it
+	SummaryID   string // the ID of the summary, set on the messages it covers
+	CompactedBy string // the ID of the summary the message was compacted into
+	// the tools called by the (assistant) message, or the call whose output
+	// is the (tool) message
+	ToolCalls []ToolCall
 }
 
 var (
-	LEFT_HALF_CIRCLE  string = string(0xe0b6)
-	RIGHT_HALF_CIRCLE string = string(0xe0b4)
-	BORDER_TOP_LEFT   string = string(0x256d)
-	BORDER_TOP_RIGHT  string = string(0x256e)
-	BORDER_HORIZONTAL string = string(0x2500)
+	LEFT_HALF_CIRCLE  string = string(rune(0xe0b6))
+	RIGHT_HALF_CIRCLE string = string(rune(0xe0b4))
+	BORDER_TOP_LEFT   string = string(rune(0x256d))
+	BORDER_TOP_RIGHT  string = string(rune(0x256e))
+	BORDER_HORIZONTAL string = string(rune(0x2500))
 )
 
 // Helper function to center a string within a given width (top rounded border)
@@ -136,43 +124,80 @@ func addToBorder(content string, border string, color lipgloss.Color, id string)
 	return lipgloss.JoinVertical(lipgloss.Top, centered, content)
 }
 
-// Encodes the provided messages to gob format and writes it to the writer
-func EncodeGob(w io.Writer, messages *[]ChatMessage) error {
-	if err := gob.NewEncoder(w).Encode(messages); err != nil {
-		return fmt.Errorf("encode: %w", err)
-	}
-	return nil
-}
-
-// Decodes the gob-encoded messages from the reader and stores them in the
-// provided messages slice
-func DecodeGob(r io.Reader, messages *[]ChatMessage) error {
-	if err := gob.NewDecoder(r).Decode(messages); err != nil {
-		return fmt.Errorf("decode: %w", err)
-	}
-	return nil
-}
-
 type Chat struct {
 	imagepicker          filepicker.Model
 	help                 help.Model
 	promptForm           *huh.Form
-	Glamour              *glamour.TermRenderer
+	promptField          *huh.Text
+	promptFieldHeight    int
+	markdown             markdownRenderer
+	bubbleCache          map[uint64]string
+	requestedImages      map[imageKey]bool
+	imageQueue           []imageKey
+	renderGeneration     int
+	lastRepaint          time.Time
 	modelName            string
-	attachedImage        string
+	attachments          []string
+	attachedFiles        map[string]FileAttachment
+	traySelected         int
 	notification         string
 	viewport             viewport.Model
 	chatState            []string
-	ChatHistory          []ChatMessage
+	history              *ChatNode
+	ChatHistory          []*ChatNode
 	ChatSettings         client.Chat
+	cancelStream         context.CancelFunc
+	streamID             int // the ID of the last stream, see streamMsg
+	cancelExec           context.CancelFunc
+	pendingExec          *CodeBlock
+	pendingTool          *ToolCall
+	approval             *approvalPreview // the code of pendingExec or the arguments of pendingTool
+	toolQueue            []ToolCall
+	toolCtx              context.Context
+	toolRounds           int
+	mcpServers           []*mcpServer
+	streamErr            error
+	compactTokens        int  // the estimated tokens of the conversation before the last compaction
+	compactStalled       bool // the last compaction didn't reduce the tokens, see autoCompact
+	editing              *ChatNode
+	settingsForm         *huh.Form
+	settingsDraft        *settingsDraft
+	searchInput          textinput.Model
+	codeBlockInput       textinput.Model
+	codeBlocks           []CodeBlock
+	codeBlockIndex       int
+	overwritePath        string
+	searchQuery          string
+	searchMatches        []searchMatch
+	searchIndex          int
+	pendingVimKey        string
+	vimSequence          int
+	messageOffsets       []int
+	models               []oapi.ListModelResponse
+	modelContext         ContextLengthMsg
+	gauge                contextGauge
+	completions          []string
+	completionPrefix     string
+	lastCompletion       string
+	completionIndex      int
+	draft                string
 	width                int
 	highlightedChatIndex int
 	height               int
 	isMultiModal         bool
 	streaming            bool
+	repaintPending       bool
 	pickingImage         bool
 	helpVisible          bool
+	confirmingDelete     bool
+	confirmingClear      bool // the delete overlay asks to clear the chat
+	trayFocused          bool
+	pickingCodeBlock     bool
+	executing            bool
+	savingCodeBlock      bool
+	searching            bool
 	notificationVisible  bool
+	normalMode           bool
 }
 
 type clearNotificationMsg struct{}
@@ -213,72 +238,70 @@ func NewChat(chatSettings client.Chat) *Chat {
 	vp := viewport.New(30, 5)
 
 	fp := filepicker.New()
-	fp.AllowedTypes = []string{".png", ".jpeg", ".jpg"}
 	fp.CurrentDirectory, _ = os.UserHomeDir()
 	// fp.Height = 10
 	fp.AutoHeight = true
 
 	highlightedChatIndex := 0
 
-	var chatHistory []ChatMessage
+	history := &ChatNode{}
 
 	if !chatSettings.IsAnonymous {
 		// make sure the chat history file exists
-		if _, err := os.Stat(filepath.Join(
-			xdg.DataHome,
-			"gollama",
-			"chats",
-			chatSettings.ID+".gob",
-		)); os.IsNotExist(err) {
+		if _, err := os.Stat(HistoryPath(chatSettings.ID)); os.IsNotExist(err) {
 			// create the chat history file
-			file, err := os.Create(filepath.Join(
-				xdg.DataHome,
-				"gollama",
-				"chats",
-				chatSettings.ID+".gob",
-			))
+			file, err := os.Create(HistoryPath(chatSettings.ID))
 			if err != nil {
 				utils.PrintError(err, true)
 			}
 			defer file.Close() //nolint:errcheck
 		} else {
 			// read the chat history file
-			file, err := os.Open(filepath.Join(
-				xdg.DataHome,
-				"gollama",
-				"chats",
-				chatSettings.ID+".gob",
-			))
+			file, err := os.Open(HistoryPath(chatSettings.ID))
 			if err != nil {
 				utils.PrintError(err, true)
 			}
 			defer file.Close() //nolint:errcheck
 
-			if err := DecodeGob(file, &chatHistory); err != nil {
+			if err := DecodeGob(file, history); err != nil {
 				utils.PrintError(err, true)
 			}
+		}
+	}
 
-			// if the chat history is not empty, set the highlighted chat index to the last message
-			if len(chatHistory) > 0 {
-				highlightedChatIndex = len(chatHistory) - 1
-			}
+	// responses saved before the model was stored with every message were
+	// written by the chat's model
+	history.Walk(func(node *ChatNode) {
+		if node.Role == roles.ASSISTANT && node.Model == "" {
+			node.Model = chatSettings.ModelName
 		}
+	})
+
+	chatHistory := history.ActivePath()
+
+	// if the chat history is not empty, set the highlighted chat index to the last message
+	if len(chatHistory) > 0 {
+		highlightedChatIndex = len(chatHistory) - 1
 	}
 
 	helpModel := help.New()
 	helpModel.ShowAll = true
 	helpModel.Styles.FullDesc.UnsetForeground()
-	helpModel.Styles.FullKey = lipgloss.NewStyle().Foreground(lipgloss.AdaptiveColor{Light: "#43BF6D", Dark: "#73F59F"})
+	helpModel.Styles.FullKey = lipgloss.NewStyle().Foreground(helpKeyColor)
 
 	return &Chat{
 		modelName:            chatSettings.ModelName,
 		imagepicker:          fp,
+		history:              history,
 		ChatHistory:          chatHistory,
 		isMultiModal:         chatSettings.IsMultiModal,
 		viewport:             vp,
 		ChatSettings:         chatSettings,
 		highlightedChatIndex: highlightedChatIndex,
 		help:                 helpModel,
+		searchInput:          newSearchInput(),
+		codeBlockInput:       newCodeBlockInput(),
+		attachedFiles:        map[string]FileAttachment{},
 	}
 }
 
@@ -287,6 +310,7 @@ type CopyType string
 const (
 	CopyLastResponse CopyType = "CopyLastResponse"
 	CopyHighlighted  CopyType = "CopyHighlighted"
+	CopyCodeBlock    CopyType = "CopyCodeBlock"
 )
 
 // Helper function to copy the provided content to the clipboard
@@ -297,14 +321,14 @@ func (chat *Chat) CopyToClipboard(content string, copyType CopyType) tea.Cmd {
 
 	switch copyType {
 	case CopyLastResponse:
-		chat.notification = "Copied last response to clipboard"
+		return chat.notify("Copied last response to clipboard")
 	case CopyHighlighted:
-		chat.notification = "Copied highlighted message to clipboard"
+		return chat.notify("Copied highlighted message to clipboard")
+	case CopyCodeBlock:
+		return chat.notify("Copied code block to clipboard")
 	}
 
-	chat.notificationVisible = true
-
-	return clearNotificationAfter(time.Second * 3)
+	return nil
 }
 
 // Renders an image using the provided path and height
@@ -387,9 +411,15 @@ func (chat *Chat) resetPrompt(
 ) []tea.Cmd {
 	cmds := []tea.Cmd{}
 
+	title := "Chat with "
+	if chat.editing != nil {
+		title = "Edit message for "
+	}
+
 	textField := huh.NewText().
 		Key("message").
-		Title(titleStyle.Render("Chat with ") + makeRounded(lipgloss.
+		Value(&chat.draft).
+		Title(titleStyle.Render(title) + makeRounded(lipgloss.
 			NewStyle().
 			Background(bg).
 			Foreground(fg).
@@ -398,12 +428,17 @@ func (chat *Chat) resetPrompt(
 			Render(chat.modelName), bg)).
 		Placeholder(placeholder).
 		Validate(func(s string) error {
-			if focus && len(strings.TrimSpace(s)) == 0 {
+			// attachments can be sent without a prompt
+			if focus && len(strings.TrimSpace(s)) == 0 && len(chat.attachments) == 0 {
 				return errors.New("prompt cannot be empty")
 			}
 			return nil
-		}).
-		WithHeight(3)
+		})
+
+	// the prompt grows with the lines of the draft
+	chat.promptField = textField
+	chat.promptFieldHeight = chat.promptHeight()
+	textField.WithHeight(chat.promptFieldHeight)
 
 	chat.promptForm = huh.NewForm(
 		huh.NewGroup(
@@ -411,11 +446,13 @@ func (chat *Chat) resetPrompt(
 		),
 	).
 		WithWidth(max(30, chat.width)).
-		WithShowHelp(false)
+		WithShowHelp(false).
+		WithKeyMap(promptKeyMap())
 
 	cmds = append(cmds, chat.promptForm.Init())
 
-	if !focus {
+	// the prompt stays blurred in normal mode until the insert mode is entered
+	if !focus || chat.normalMode {
 		cmds = append(cmds, textField.Blur())
 	}
 
@@ -431,8 +468,8 @@ func (chat *Chat) Init() tea.Cmd {
 	chat.height = physicalHeight
 
 	cmds := chat.resetPrompt(
-		purple,
-		cream,
+		primaryColor,
+		foregroundColor,
 		"Type your message here...",
 		HighlightForegroundStyle,
 		true,
@@ -440,7 +477,9 @@ func (chat *Chat) Init() tea.Cmd {
 
 	cmds = append(cmds, chat.imagepicker.Init())
 
-	cmds = append(cmds, chat.Resize())
+	cmds = append(cmds, fetchModels, fetchContextLength(chat.modelName))
+
+	cmds = append(cmds, chat.Resize(), chat.renderQueuedImages(), chat.syncMCPServers())
 
 	return tea.Batch(
 		cmds...,
@@ -450,38 +489,110 @@ func (chat *Chat) Init() tea.Cmd {
 // Redraws the chat's viewport
 func (chat *Chat) redrawViewport() {
 	if len(chat.chatState) == 0 {
+		chat.viewport.SetContent("")
 		return
 	}
 
 	state := []string{}
+	matches := []searchMatch{}
+	chat.messageOffsets = make([]int, len(chat.ChatHistory))
+	offset := 0
 
 	for i := range chat.ChatHistory {
 		message := chat.chatState[i]
+		if message == "" {
+			message = chat.placeholderBubble(chat.ChatHistory[i], fmt.Sprintf("%d", i))
+		}
 		if i == chat.highlightedChatIndex {
 			message = chat.getMessageBubble(chat.ChatHistory[chat.highlightedChatIndex], true, fmt.Sprintf("%d", chat.highlightedChatIndex))
 		}
 
+		if chat.searchQuery != "" {
+			var messageMatches []searchMatch
+			message, messageMatches = chat.highlightSearchMatches(message, i)
+			matches = append(matches, messageMatches...)
+		}
+
+		chat.messageOffsets[i] = offset
+		offset += lipgloss.Height(message)
+
 		state = append(state, message)
 
 		if chat.ChatHistory[i].Role == roles.ASSISTANT {
+			footer := humanize.Time(chat.ChatHistory[i].CreatedAt)
+			if metrics := chat.ChatHistory[i].Metrics; metrics != nil {
+				footer += " • " + metrics.String()
+			}
+			if chat.ChatHistory[i].Interrupted {
+				footer += " • interrupted"
+			}
+
 			state = append(state,
 				lipgloss.
 					NewStyle().
 					Padding(0, 1).
-					Foreground(gray).
-					Render(
-						humanize.
-							Time(
-								chat.ChatHistory[i].CreatedAt,
-							),
-					),
+					Foreground(mutedColor).
+					Render(footer),
 			)
+			offset++
 		}
 	}
 
+	chat.searchMatches = matches
+
+	if chat.streamErr != nil {
+		state = append(state, chat.getErrorBubble(chat.streamErr))
+	}
+
 	chat.viewport.SetContent(lipgloss.JoinVertical(lipgloss.Top, state...))
 }
 
+// Returns the model that wrote the message, messages saved before the model
+// was stored with them fall back to the chat's model
+func (chat *Chat) messageModel(msg *ChatNode) string {
+	if msg.Model != "" {
+		return msg.Model
+	}
+	return chat.modelName
+}
+
+// Helper function to get the bubble for a failed request, it is only shown in
+// the viewport and never stored in (or sent with) the chat history
+func (chat *Chat) getErrorBubble(err error) string {
+	width := chat.width / 2
+
+	if chat.width < 80 {
+		width = chat.width - 6
+	}
+
+	body := lipgloss.JoinVertical(
+		lipgloss.Left,
+		err.Error(),
+		"",
+		lipgloss.NewStyle().Foreground(mutedColor).Render("Press "+Keys.Retry.Help().Key+" to retry"),
+	)
+
+	bubble := addToBorder(
+		RoundedBorder.
+			Border(lipgloss.RoundedBorder(), false, true, true).
+			Width(min(width+4, max(9, lipgloss.Width(body)+4))).
+			Padding(0, 2).
+			Foreground(foregroundColor).
+			BorderForeground(dangerColor).
+			Render(body),
+		ErrorStyle.Render("error"),
+		dangerColor,
+		"",
+	)
+
+	return lipgloss.NewStyle().
+		Width(chat.width).
+		Align(lipgloss.Left).
+		Render(
+			bubble,
+		)
+}
+
 // Updates the chat's viewport by redrawing it and scrolling to the bottom
 func (chat *Chat) updateViewport() {
 	if len(chat.chatState) == 0 {
@@ -507,44 +618,158 @@ func fixMarkdown(msg string) string {
 	return msg
 }
 
-// Helper function to get the message bubble for the provided message
-func (chat *Chat) getMessageBubble(msg ChatMessage, isSelected bool, id string) string {
-	align := lipgloss.Right
+// The inputs of a message bubble, they are collected on the UI goroutine so
+// the bubble can be rendered in the background
+type bubbleSpec struct {
+	role      string
+	title     string
+	body      string // the markdown of the body
+	message   string // the raw message, shown below the images
+	id        string
+	files     []FileAttachment
+	images    []imageKey
+	width     int // the width of the chat
+	collapsed bool
+	selected  bool
+	streaming bool
+	theme     bubbleTheme
+}
+
+// Collects the inputs of the bubble for the provided message
+func (chat *Chat) bubbleSpec(msg *ChatNode, isSelected bool, id string) bubbleSpec {
 	title := msg.Role
 	body := msg.Message
 	isLastMessage := id == fmt.Sprintf("%d", len(chat.ChatHistory)-1)
-
-	padding := []int{0, 2, 0, 0}
+	streaming := false
 
 	if msg.Role == roles.ASSISTANT {
-		align = lipgloss.Left
-		title = chat.modelName
-
-		if msg.Message == "" {
-			body = fmt.Sprintf("_Waiting for %s..._", chat.modelName)
+		title = chat.messageModel(msg)
+
+		switch {
+		case len(msg.ToolCalls) > 0 && msg.Message == "":
+			body = toolCallsView(msg.ToolCalls)
+		case len(msg.ToolCalls) > 0:
+			body = msg.Message + "\n\n" + toolCallsView(msg.ToolCalls)
+		case msg.Message == "":
+			body = fmt.Sprintf("_Waiting for %s..._", title)
+			if msg.Interrupted {
+				body = "_Response stopped before any output_"
+			}
+		}
+		if msg.Summary {
+			title = "summary by " + title
+			if msg.Message == "" {
+				body = "_Summarizing the conversation..._"
+			}
 		}
 		if chat.streaming && isLastMessage {
 			body = fixMarkdown(body)
+			streaming = true
 		}
 	}
 
-	var err error
-	width := chat.width / 2
+	if msg.Role == roles.TOOL && len(msg.ToolCalls) > 0 {
+		title = "tool • " + msg.ToolCalls[0].Name
+		body = toolOutputView(msg.ToolCalls[0], msg.Message)
+	}
 
-	if chat.width < 80 {
-		width = chat.width - 6
+	// show which of the alternatives (e.g. regenerated responses) this is
+	if position, total := msg.Position(); total > 1 {
+		title = fmt.Sprintf("%s %d/%d", title, position+1, total)
 	}
 
-	chat.Glamour, _ = glamour.NewTermRenderer(
-		glamour.WithStandardStyle("dracula"),
-		glamour.WithWordWrap(min(width, lipgloss.Width(body)+4)),
-	)
-	bodyNew, err := chat.Glamour.Render(body)
-	if err != nil {
-		bodyNew = body
+	// messages replaced by a summary (or folded) are only shown as a one line
+	// preview
+	compacted := chat.isCompacted(msg)
+	collapsed := compacted || msg.Collapsed
+	switch {
+	case compacted:
+		title += " • compacted"
+	case msg.Collapsed:
+		title += " • collapsed"
+	}
+	if collapsed {
+		preview := msg.Message
+		if msg.Role == roles.TOOL && len(msg.ToolCalls) > 0 {
+			preview = msg.ToolCalls[0].String()
+		}
+		body = "_" + collapsedPreview(preview) + "_"
+	}
+
+	// the images share the height of the viewport, they are rendered in the
+	// background
+	images := []imageKey{}
+	if len(msg.Images) > 0 && !collapsed {
+		imageHeight := max(4, (chat.viewport.Height-lipgloss.Height(msg.Message))/len(msg.Images))
+
+		for _, path := range msg.Images {
+			key := newImageKey(path, imageHeight)
+			if _, ok := cachedImage(key); !ok {
+				chat.requestImage(key)
+			}
+			images = append(images, key)
+		}
+	}
+
+	return bubbleSpec{
+		role:      msg.Role,
+		title:     title,
+		body:      body,
+		message:   msg.Message,
+		id:        id,
+		files:     msg.Files,
+		images:    images,
+		width:     chat.width,
+		collapsed: collapsed,
+		selected:  isSelected,
+		streaming: streaming,
+		theme:     currentBubbleTheme(),
+	}
+}
+
+// Helper function to get the message bubble for the provided message, the
+// bubbles are cached until the message (or the size of the chat) changes
+func (chat *Chat) getMessageBubble(msg *ChatNode, isSelected bool, id string) string {
+	spec := chat.bubbleSpec(msg, isSelected, id)
+
+	// the streamed response changes with every chunk
+	if spec.streaming {
+		return chat.markdown.bubble(spec)
 	}
 
-	body = bodyNew
+	key := spec.key()
+	if bubble, ok := chat.bubbleCache[key]; ok {
+		return bubble
+	}
+
+	bubble := chat.markdown.bubble(spec)
+	chat.cacheBubble(key, bubble)
+
+	return bubble
+}
+
+// Renders the message bubble
+func (md *markdownRenderer) bubble(spec bubbleSpec) string {
+	align := lipgloss.Right
+	body := spec.body
+	padding := []int{0, 2, 0, 0}
+
+	if spec.role == roles.ASSISTANT || spec.role == roles.TOOL {
+		align = lipgloss.Left
+	}
+
+	width := spec.width / 2
+
+	if spec.width < 80 {
+		width = spec.width - 6
+	}
+
+	wrap := min(width, lipgloss.Width(body)+4)
+	if spec.streaming {
+		body = md.renderStreaming(body, wrap)
+	} else {
+		body = md.render(body, wrap)
+	}
 
 	// strip the last line if it's empty
 	lastLine := strings.Split(body, "\n")[len(strings.Split(body, "\n"))-1]
@@ -552,35 +777,44 @@ func (chat *Chat) getMessageBubble(msg ChatMessage, isSelected bool, id string)
 		body = strings.TrimSuffix(body, "\n")
 	}
 
-	for i := range msg.Images {
+	// the attached files are only shown by name, their content is sent to the
+	// model but would flood the chat
+	if len(spec.files) > 0 && !spec.collapsed {
+		body = lipgloss.JoinVertical(lipgloss.Left, body, "", fileChipsView(spec.files, spec.theme.title))
+	}
+
+	if len(spec.images) > 0 {
+		images := []string{}
+		for _, key := range spec.images {
+			images = append(images, imageView(key, spec.theme.muted), "")
+		}
+
 		body = lipgloss.JoinVertical(
 			lipgloss.Left,
-			renderImage(
-				msg.Images[i],
-				chat.viewport.Height-lipgloss.Height(body),
-			),
-			"",
-			msg.Message,
+			append(images, spec.message)...,
 		)
 
 		padding = []int{1, 2, 0, 2}
 	}
 
-	borderColor := purple
-	titleStyle := HighlightStyle
-	if isSelected {
-		borderColor = teal
-		titleStyle = HighlightActiveStyle
+	borderColor := spec.theme.primary
+	titleStyle := spec.theme.title
+	if spec.collapsed {
+		borderColor = spec.theme.muted
+	}
+	if spec.selected {
+		borderColor = spec.theme.active
+		titleStyle = spec.theme.activeTitle
 	}
 
 	alignText := lipgloss.Left
-	if msg.Role == roles.USER {
-		if lipgloss.Width(body) < len(title)+4 || !strings.Contains(body, "\n") {
+	if spec.role == roles.USER {
+		if lipgloss.Width(body) < len(spec.title)+4 || !strings.Contains(body, "\n") {
 			alignText = lipgloss.Center
 		}
 	}
 
-	width = min(width+4, max(len(title)+4, lipgloss.Width(body)+4))
+	width = min(width+4, max(len(spec.title)+4, lipgloss.Width(body)+4))
 
 	bubble := addToBorder(
 		RoundedBorder.
@@ -588,16 +822,16 @@ func (chat *Chat) getMessageBubble(msg ChatMessage, isSelected bool, id string)
 			Align(alignText).
 			Width(width).
 			Padding(padding...).
-			Foreground(cream).
+			Foreground(spec.theme.foreground).
 			BorderForeground(borderColor).
 			Render(body),
-		titleStyle.Render(title),
+		titleStyle.Render(spec.title),
 		borderColor,
-		id,
+		spec.id,
 	)
 
 	return lipgloss.NewStyle().
-		Width(chat.width).
+		Width(spec.width).
 		Align(align).
 		Render(
 			bubble,
@@ -609,28 +843,58 @@ func (chat *Chat) getMessageBubble(msg ChatMessage, isSelected bool, id string)
 // and sends the message to the Ollama server using the API
 func (chat *Chat) sendMessage(prompt string, role string) tea.Cmd {
 	msg := strings.TrimSpace(prompt)
-	if len(msg) == 0 && role == roles.USER {
+	if len(msg) == 0 && role == roles.USER && len(chat.attachments) == 0 {
 		return nil
 	}
 
-	images := []string{}
-	if chat.attachedImage != "" {
-		images = append(images, chat.attachedImage)
+	currentMessage := ChatMessage{
+		Role:    role,
+		Message: msg,
 	}
 
-	currentMessage := ChatMessage{
-		Role:      role,
-		Message:   msg,
-		Images:    images,
-		CreatedAt: time.Now(),
+	// the attachments in the tray are sent with the prompt
+	if role == roles.USER {
+		for _, path := range chat.attachments {
+			if isImage(path) {
+				currentMessage.Images = append(currentMessage.Images, path)
+			} else {
+				currentMessage.Files = append(currentMessage.Files, chat.attachedFiles[path])
+			}
+		}
+		chat.clearAttachments()
 	}
 
-	chat.ChatHistory = append(chat.ChatHistory, currentMessage)
+	if role == roles.ASSISTANT {
+		currentMessage.Model = chat.modelName
+	}
+
+	chat.addMessage(currentMessage)
+
+	if role == roles.USER {
+		chat.toolRounds = 0
+		return chat.streamResponse()
+	}
+
+	return nil
+}
+
+// Adds the message to the end of the active conversation and renders it, the
+// attachment tray is left as is
+func (chat *Chat) addMessage(currentMessage ChatMessage) *ChatNode {
+	currentMessage.CreatedAt = time.Now()
+
+	parent := chat.history
+	if len(chat.ChatHistory) > 0 {
+		parent = chat.ChatHistory[len(chat.ChatHistory)-1]
+	}
+
+	node := parent.AddChild(currentMessage)
+	chat.ChatHistory = append(chat.ChatHistory, node)
 
 	chat.highlightedChatIndex = len(chat.ChatHistory) - 1
 
 	msgBubble := chat.getMessageBubble(
-		currentMessage,
+		node,
 		false,
 		fmt.Sprintf("%d", len(chat.ChatHistory)-1),
 	)
@@ -638,74 +902,423 @@ func (chat *Chat) sendMessage(prompt string, role string) tea.Cmd {
 
 	chat.updateViewport()
 
-	chat.attachedImage = ""
+	return node
+}
 
-	if role == roles.USER {
-		chat.streaming = true
-		chat.sendMessage("", roles.ASSISTANT)
+// Builds the list of messages sent to Ollama from the system message and the
+// chat history (trimmed to the context window according to the chat's
+// strategy), reading the attached images from disk
+func (chat *Chat) requestMessages() ([]oapi.Message, error) {
+	history, _, err := chat.contextMessages(chat.ChatHistory)
+	if err != nil {
+		return nil, err
+	}
+
+	chatHistory := []oapi.Message{}
+
+	// check if the system message is set
+	if strings.TrimSpace(chat.ChatSettings.SystemMessage) != "" {
+		chatHistory = []oapi.Message{
+			{
+				Role:    roles.SYSTEM,
+				Content: chat.ChatSettings.SystemMessage,
+			},
+		}
+	}
+
+	for _, msg := range history {
+		if msg.Summary {
+			chatHistory = append(chatHistory, summaryMessage(msg))
+			continue
+		}
+
+		imageData := []oapi.ImageData{}
+		for _, img := range msg.Images {
+			expandedPath, err := utils.ExpandPath(img)
+			if err != nil {
+				continue
+			}
+			imgData, err := os.ReadFile(expandedPath)
+			if err == nil {
+				imageData = append(imageData, imgData)
+			}
+		}
+
+		message := oapi.Message{
+			Role:    msg.Role,
+			Content: msg.requestContent(),
+			Images:  imageData,
+		}
+		if msg.Role == roles.ASSISTANT {
+			for _, call := range msg.ToolCalls {
+				message.ToolCalls = append(message.ToolCalls, call.request())
+			}
+		}
+
+		chatHistory = append(chatHistory, message)
+	}
+
+	return chatHistory, nil
+}
+
+// Streams a response to the current chat history from the model, the chunks
+// are written to an (initially empty) assistant message
+func (chat *Chat) streamResponse() tea.Cmd {
+	messages, err := chat.requestMessages()
+	return chat.streamRequest(messages, tools.Definitions(chat.availableTools()), err)
+}
+
+// Streams the model's response to the provided messages into a new assistant
+// message, the model can call the provided tools, err is reported as the
+// stream's error without sending the request
+func (chat *Chat) streamRequest(messages []oapi.Message, toolDefinitions oapi.Tools, err error) tea.Cmd {
+	chat.streaming = true
+	chat.streamErr = nil
+	chat.sendMessage("", roles.ASSISTANT)
+
+	chat.streamID++
+	stream := chat.streamID
+
+	// the request doesn't fit in the context window
+	if err != nil {
 		return func() tea.Msg {
-			ctx := context.Background()
+			return streamMsg{stream, StreamError{Err: err}}
+		}
+	}
 
-			chatHistory := []oapi.Message{}
+	// the context is cancelled when the user stops the response mid-stream
+	ctx, cancel := context.WithCancel(context.Background())
+	chat.cancelStream = cancel
 
-			// check if the system message is set
-			if strings.TrimSpace(chat.ChatSettings.SystemMessage) != "" {
-				chatHistory = []oapi.Message{
-					{
-						Role:    roles.SYSTEM,
-						Content: chat.ChatSettings.SystemMessage,
-					},
-				}
+	return func() tea.Msg {
+		chatRequest := oapi.ChatRequest{
+			Model:    chat.modelName,
+			Messages: messages,
+			Options:  chat.requestOptions(),
+		}
+		if len(toolDefinitions) > 0 {
+			chatRequest.Tools = toolDefinitions
+		}
+
+		start := time.Now()
+		var timeToFirstToken time.Duration
+
+		send := func(msg tea.Msg) {
+			client.GollamaInstance.Program.Send(streamMsg{stream, msg})
+		}
+
+		err := client.GollamaInstance.API.Client.Chat(ctx, &chatRequest, func(response oapi.ChatResponse) error {
+			if err := ctx.Err(); err != nil {
+				return err
 			}
 
-			for _, msg := range chat.ChatHistory {
-				imageData := []oapi.ImageData{}
-				for _, img := range msg.Images {
-					expandedPath, err := utils.ExpandPath(img)
-					if err != nil {
-						continue
-					}
-					imgData, err := os.ReadFile(expandedPath)
-					if err == nil {
-						imageData = append(imageData, imgData)
-					}
-				}
+			if timeToFirstToken == 0 && response.Message.Content != "" {
+				timeToFirstToken = time.Since(start)
+			}
 
-				chatHistory = append(chatHistory, oapi.Message{
-					Role:    msg.Role,
-					Content: msg.Message,
-					Images:  imageData,
-				})
+			// send the response to the bubbletea channel here...
+			send(StreamChunk(response.Message.Content))
+			if len(response.Message.ToolCalls) > 0 {
+				send(StreamToolCalls(newToolCalls(response.Message.ToolCalls)))
 			}
 
-			chatRequest := oapi.ChatRequest{
-				Model:    chat.modelName,
-				Messages: chatHistory,
+			// the final chunk carries the metrics of the whole response
+			if response.Done {
+				send(StreamMetrics(newMessageMetrics(response.Metrics, timeToFirstToken)))
 			}
+			return nil
+		})
 
-			client.GollamaInstance.API.Client.Chat(ctx, &chatRequest, func(response oapi.ChatResponse) error {
-				// send the response to the bubbletea channel here...
-				client.GollamaInstance.Program.Send(StreamChunk(response.Message.Content))
-				return nil
-			})
-			return FinishedStreaming(true)
+		// the stream was cancelled by the user, the chat has already been
+		// restored by stopStreaming
+		if ctx.Err() != nil {
+			return nil
 		}
+
+		if err != nil {
+			return streamMsg{stream, StreamError{Err: err}}
+		}
+
+		return streamMsg{stream, FinishedStreaming(true)}
 	}
+}
 
-	return nil
+// Removes the last message from the active conversation (and its rendered
+// bubble), if remove is set the message is deleted from the history tree too
+func (chat *Chat) popMessage(remove bool) {
+	if len(chat.ChatHistory) == 0 {
+		return
+	}
+
+	if remove {
+		last := chat.ChatHistory[len(chat.ChatHistory)-1]
+		last.parent.RemoveChild(last)
+	}
+
+	chat.ChatHistory = chat.ChatHistory[:len(chat.ChatHistory)-1]
+	chat.chatState = chat.chatState[:len(chat.chatState)-1]
+
+	chat.highlightedChatIndex = min(chat.highlightedChatIndex, len(chat.ChatHistory)-1)
+	chat.highlightedChatIndex = max(chat.highlightedChatIndex, 0)
+}
+
+// Handles a failed request, the empty assistant message is dropped (a partial
+// one is kept and marked as interrupted) and the error is shown in the chat
+func (chat *Chat) failStreaming(err error) tea.Cmd {
+	if chat.isCompacting() {
+		return chat.failCompacting(err)
+	}
+
+	last := len(chat.ChatHistory) - 1
+	if chat.ChatHistory[last].Role == roles.ASSISTANT {
+		if chat.ChatHistory[last].Message == "" {
+			chat.popMessage(true)
+		} else {
+			chat.ChatHistory[last].Interrupted = true
+		}
+	}
+
+	chat.streamErr = err
+
+	return chat.finishStreaming()
+}
+
+// Resends the last user message after a failed request, any partial response
+// to it is discarded
+func (chat *Chat) retry() tea.Cmd {
+	if chat.streamErr == nil || len(chat.ChatHistory) == 0 {
+		return nil
+	}
+
+	if chat.ChatHistory[len(chat.ChatHistory)-1].Role == roles.ASSISTANT {
+		chat.popMessage(true)
+	}
+
+	return chat.resendLastMessage()
+}
+
+// Generates a new response to the last user message, the previous responses
+// are kept as alternatives that can be cycled through
+func (chat *Chat) regenerate() tea.Cmd {
+	if len(chat.ChatHistory) == 0 {
+		return nil
+	}
+
+	last := chat.ChatHistory[len(chat.ChatHistory)-1]
+	if last.Summary {
+		return nil
+	}
+
+	if last.Role == roles.ASSISTANT {
+		chat.popMessage(false)
+	}
+
+	return chat.resendLastMessage()
+}
+
+// Streams a new response to the last message of the active conversation (if
+// it was sent by the user or is the output of a tool) and disables the prompt
+// while streaming
+func (chat *Chat) resendLastMessage() tea.Cmd {
+	if len(chat.ChatHistory) == 0 {
+		return nil
+	}
+	if role := chat.ChatHistory[len(chat.ChatHistory)-1].Role; role != roles.USER && role != roles.TOOL {
+		return nil
+	}
+
+	streamCmd := chat.streamResponse()
+	resetChatCmd := chat.resetPrompt(
+		mutedColor,
+		contrastColor,
+		"Disabled while response is being streamed...",
+		DisabledHighlightStyle,
+		false,
+	)
+
+	return tea.Batch(append(resetChatCmd, streamCmd)...)
+}
+
+// Switches the highlighted message to its previous (-1) or next (+1)
+// alternative, the rest of the conversation follows the selected alternative
+func (chat *Chat) cycleAlternative(delta int) tea.Cmd {
+	if len(chat.ChatHistory) == 0 {
+		return nil
+	}
+
+	node := chat.ChatHistory[chat.highlightedChatIndex]
+	position, total := node.Position()
+	if total < 2 {
+		return nil
+	}
+
+	node.parent.Selected = (position + delta + total) % total
+	chat.ChatHistory = chat.history.ActivePath()
+
+	cmd := chat.renderChatState()
+	chat.redrawViewport()
+
+	return cmd
+}
+
+// Folds (or unfolds) the highlighted message to its first line
+func (chat *Chat) toggleCollapsed() {
+	if len(chat.ChatHistory) == 0 {
+		return
+	}
+
+	node := chat.ChatHistory[chat.highlightedChatIndex]
+	node.Collapsed = !node.Collapsed
+
+	chat.chatState[chat.highlightedChatIndex] = chat.getMessageBubble(
+		node,
+		false,
+		fmt.Sprintf("%d", chat.highlightedChatIndex),
+	)
+	chat.redrawViewport()
+	chat.viewport.SetYOffset(chat.messageOffsets[chat.highlightedChatIndex])
+}
+
+// Starts (or cancels) editing the highlighted user message, the message is
+// loaded into the prompt and sent as a new branch of the conversation
+func (chat *Chat) toggleEditing() tea.Cmd {
+	if chat.editing != nil {
+		chat.editing = nil
+		chat.draft = ""
+		chat.clearAttachments()
+	} else {
+		if len(chat.ChatHistory) == 0 {
+			return nil
+		}
+
+		node := chat.ChatHistory[chat.highlightedChatIndex]
+		if node.Role != roles.USER {
+			return nil
+		}
+
+		chat.editing = node
+		chat.draft = node.Message
+		chat.clearAttachments()
+		chat.attachments = slices.Clone(node.Images)
+		for _, file := range node.Files {
+			chat.attachments = append(chat.attachments, file.Path)
+			chat.attachedFiles[file.Path] = file
+		}
+	}
+
+	return tea.Batch(
+		chat.resetPrompt(
+			primaryColor,
+			foregroundColor,
+			"Type your message here...",
+			HighlightForegroundStyle,
+			true,
+		)...,
+	)
+}
+
+// Truncates the active conversation right before the provided message, the
+// next message sent becomes an alternative (sibling) of it
+func (chat *Chat) forkAt(node *ChatNode) {
+	for idx := range chat.ChatHistory {
+		if chat.ChatHistory[idx] == node {
+			chat.ChatHistory = chat.ChatHistory[:idx]
+			chat.chatState = chat.chatState[:idx]
+			break
+		}
+	}
+
+	chat.highlightedChatIndex = max(0, len(chat.ChatHistory)-1)
+	chat.streamErr = nil
+}
+
+// Writes the chat history to disk (anonymous chats are never saved)
+func (chat *Chat) SaveHistory() error {
+	if chat.ChatSettings.IsAnonymous {
+		return nil
+	}
+
+	file, err := os.Create(HistoryPath(chat.ChatSettings.ID))
+	if err != nil {
+		return fmt.Errorf("could not save chat history: %w", err)
+	}
+	defer file.Close() //nolint:errcheck
+
+	return EncodeGob(file, chat.history)
+}
+
+// Cancels the in-flight response (if any), keeps the partial response in the
+// chat history marked as interrupted and re-enables the prompt
+func (chat *Chat) stopStreaming() tea.Cmd {
+	if !chat.streaming {
+		return nil
+	}
+
+	if chat.cancelStream != nil {
+		chat.cancelStream()
+		chat.cancelStream = nil
+	}
+	// the messages the stopped stream already sent are ignored
+	chat.streamID++
+
+	if chat.isCompacting() {
+		return chat.failCompacting(nil)
+	}
+
+	chat.ChatHistory[len(chat.ChatHistory)-1].Interrupted = true
+
+	return chat.finishStreaming()
+}
+
+// Marks the stream as done, re-renders the last message and resets the prompt
+func (chat *Chat) finishStreaming() tea.Cmd {
+	chat.streaming = false
+	chat.cancelStream = nil
+	chat.markdown.streamPrefix = renderedPrefix{}
+	if len(chat.ChatHistory) > 0 {
+		chat.chatState[len(chat.chatState)-1] = chat.getMessageBubble(chat.ChatHistory[len(chat.ChatHistory)-1], false, fmt.Sprintf("%d", len(chat.ChatHistory)-1))
+	}
+	chat.updateViewport()
+	return tea.Batch(
+		chat.resetPrompt(
+			primaryColor,
+			foregroundColor,
+			"Type your message here...",
+			HighlightForegroundStyle,
+			true,
+		)...,
+	)
 }
 
 func helpView() string {
-	helpViewStr := "←/→: Navigate • Enter: Select File • ctrl+o: Return to chat"
+	helpViewStr := "←/→: Navigate • Enter: Select File • " + Keys.ToggleImagePicker.Help().Key + ": Return to chat"
 	return helpStyle(helpViewStr)
 }
 
 func (c *Chat) textAreaHelpView() string {
-	helpViewStr := "ctrl+e open editor • enter submit • ctrl+h help"
-	if c.isMultiModal {
-		helpViewStr = "ctrl+e open editor • enter submit • ctrl+o open image picker • ctrl+h help"
+	if c.streaming {
+		return helpStyle(Keys.StopResponse.Help().Key + " stop response • " + Keys.Quit.Help().Key + " exit")
+	}
+	if c.executing {
+		return helpStyle(Keys.StopResponse.Help().Key + " stop command • " + Keys.Quit.Help().Key + " exit")
+	}
+	if c.normalMode {
+		return c.normalModeHelpView()
+	}
+
+	helpViewStr := fmt.Sprintf(
+		"enter submit • %s new line • %s open editor • %s attach file • %s help",
+		Keys.NewLine.Help().Key,
+		Keys.OpenEditor.Help().Key,
+		Keys.ToggleImagePicker.Help().Key,
+		Keys.ToggleHelp.Help().Key,
+	)
+	if c.streamErr != nil {
+		helpViewStr = Keys.Retry.Help().Key + " retry • " + helpViewStr
+	}
+	if c.editing != nil {
+		helpViewStr = Keys.EditMessage.Help().Key + " cancel edit • " + helpViewStr
 	}
-	// helpViewStr := "alt+enter / ctrl+j new line • ctrl+e open editor • enter submit • ctrl+h help"
 	return helpStyle(helpViewStr)
 }
 
@@ -723,44 +1336,127 @@ func (chat *Chat) Resize() tea.Cmd {
 	chat.promptForm = chat.promptForm.WithWidth(chat.width)
 	// chat.imagepicker.AutoHeight = true
 
-	width := chat.width / 2
+	chat.help.Width = 8 * chat.width / 10
 
-	if chat.width < 80 {
-		width = chat.width - 4
-	}
-	h := lipgloss.Height(chat.promptForm.View())
+	chat.layoutViewport()
 
-	chat.help.Width = 8 * chat.width / 10
+	cmd := chat.renderChatState()
+
+	chat.updateViewport()
+
+	return cmd
+}
+
+// Sizes the viewport to the space left by the prompt and the help line
+func (chat *Chat) layoutViewport() {
+	h := lipgloss.Height(chat.promptForm.View())
 
 	chat.viewport.Width = chat.width
 	chat.viewport.Height = chat.height - h - 4
 	if chat.viewport.Height < 0 {
 		chat.viewport.Height = 0
 	}
-	// TODO: check if error
-	chat.Glamour, _ = glamour.NewTermRenderer(
-		glamour.WithStandardStyle("dracula"),
-		glamour.WithWordWrap(width),
-		// glamour.WithPreservedNewLines(),
-	)
+}
 
-	// TODO: think of a better way to do this (maybe use a goroutine?)
-	// currently expensive when there are a lot of messages/images
+// Renders the message bubbles of the active conversation, the messages around
+// the visible part of the viewport are rendered right away and the rest in the
+// background (placeholders are shown meanwhile)
+func (chat *Chat) renderChatState() tea.Cmd {
+	chat.renderGeneration++
 	chat.chatState = []string{}
 
+	jobs := []bubbleJob{}
 	for idx, msg := range chat.ChatHistory {
-		if msg.Role != roles.SYSTEM {
-			msgBubble := chat.getMessageBubble(msg, false, fmt.Sprintf("%d", idx))
-			chat.chatState = append(chat.chatState, msgBubble)
+		if msg.Role == roles.SYSTEM {
+			continue
+		}
+
+		spec := chat.bubbleSpec(msg, false, fmt.Sprintf("%d", idx))
+		bubble, ok := chat.bubbleCache[spec.key()]
+		if !ok {
+			if spec.streaming {
+				bubble = chat.markdown.bubble(spec)
+			} else {
+				jobs = append(jobs, bubbleJob{index: len(chat.chatState), key: spec.key(), spec: spec})
+			}
 		}
+
+		chat.chatState = append(chat.chatState, bubble)
 	}
 
-	chat.updateViewport()
+	if len(jobs) == 0 {
+		return nil
+	}
 
-	return nil
+	// lay out the placeholders to find the visible messages
+	atBottom := chat.viewport.AtBottom()
+	chat.redrawViewport()
+
+	top := chat.viewport.YOffset
+	if atBottom {
+		top = chat.viewport.TotalLineCount() - chat.viewport.Height
+	}
+	// a screen above and below the visible part is rendered as well
+	top -= chat.viewport.Height
+	bottom := top + 3*chat.viewport.Height
+
+	distance := func(job bubbleJob) int {
+		start := chat.messageOffsets[job.index]
+		end := chat.viewport.TotalLineCount()
+		if job.index+1 < len(chat.messageOffsets) {
+			end = chat.messageOffsets[job.index+1]
+		}
+
+		switch {
+		case end < top:
+			return top - end
+		case start > bottom:
+			return start - bottom
+		}
+		return 0
+	}
+
+	background := []bubbleJob{}
+	for _, job := range jobs {
+		if distance(job) > 0 {
+			background = append(background, job)
+			continue
+		}
+
+		bubble := chat.markdown.bubble(job.spec)
+		chat.cacheBubble(job.key, bubble)
+		chat.chatState[job.index] = bubble
+	}
+
+	// the closest messages are rendered first
+	slices.SortStableFunc(background, func(a, b bubbleJob) int {
+		return distance(a) - distance(b)
+	})
+
+	return renderInBackground(chat.renderGeneration, background, &markdownRenderer{style: glamourStyle})
+}
+
+// Highlights the message with the provided index (clamped to the history) and
+// scrolls to its top
+func (chat *Chat) highlightMessage(index int) {
+	chat.highlightedChatIndex = max(0, min(index, len(chat.ChatHistory)-1))
+	chat.redrawViewport()
+
+	if chat.highlightedChatIndex <= 0 {
+		chat.viewport.SetYOffset(0)
+	} else {
+		chat.viewport.SetYOffset(chat.messageOffsets[chat.highlightedChatIndex])
+	}
 }
 
 func (chat *Chat) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
+	model, cmd := chat.update(msg)
+
+	// the images missing from the bubbles rendered during the update
+	return model, tea.Batch(cmd, chat.renderQueuedImages())
+}
+
+func (chat *Chat) update(msg tea.Msg) (tea.Model, tea.Cmd) {
 	var cmds []tea.Cmd
 
 	// var vpCmd tea.Cmd
@@ -769,88 +1465,142 @@ func (chat *Chat) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 
 	switch msg := msg.(type) {
 	case tea.KeyMsg:
-		switch msg.String() {
-		case "ctrl+c", "esc":
+		if chat.searching || chat.searchQuery != "" {
+			if handled, cmd := chat.handleSearchKeys(msg); handled {
+				return chat, cmd
+			}
+		}
+
+		if chat.trayFocused && !isForceQuit(msg) {
+			return chat, chat.handleTrayKeys(msg)
+		}
+
+		if chat.pickingCodeBlock && !isForceQuit(msg) {
+			return chat, chat.handleCodeBlockKeys(msg)
+		}
+
+		// the confirmation overlays handle esc themselves
+		if chat.pendingTool != nil && !isForceQuit(msg) {
+			return chat, chat.handleToolApprovalKeys(msg)
+		}
+
+		if chat.pendingExec != nil && !isForceQuit(msg) {
+			return chat, chat.handleExecKeys(msg)
+		}
+
+		if chat.confirmingDelete && !isForceQuit(msg) {
+			return chat, chat.handleDeleteKeys(msg)
+		}
+
+		if key.Matches(msg, Keys.Quit) && !chat.entersNormalMode(msg) {
+			if chat.cancelStream != nil {
+				chat.cancelStream()
+			}
+			chat.cancelExecution()
 			return chat, tea.Quit
 		}
 
-		if chat.pickingImage && msg.String() == "ctrl+o" {
+		// the prompt is disabled while a command is running
+		if chat.executing {
+			if key.Matches(msg, Keys.StopResponse) {
+				chat.cancelExecution()
+			}
+			return chat, nil
+		}
+
+		if chat.streaming && key.Matches(msg, Keys.StopResponse) {
+			return chat, chat.stopStreaming()
+		}
+
+		if chat.pickingImage && key.Matches(msg, Keys.ToggleImagePicker) {
 			chat.pickingImage = false
 			return chat, nil
 		}
 
+		if chat.settingsForm != nil && !isForceQuit(msg) {
+			return chat, chat.updateSettings(msg)
+		}
+
 		if chat.helpVisible {
-			switch keypress := msg.String(); keypress {
-			case "ctrl+h":
+			if key.Matches(msg, Keys.ToggleHelp) {
 				chat.helpVisible = false
-				return chat, nil
 			}
 			return chat, nil
 		}
 
+		if config.Current.VimMode && !chat.pickingImage {
+			if handled, cmd := chat.handleVimKeys(msg); handled {
+				return chat, cmd
+			}
+		}
+
 		if !chat.streaming && !chat.pickingImage {
-			switch msg.String() {
-			case "ctrl+o":
-				if chat.isMultiModal {
-					chat.pickingImage = true
-					return chat, nil
-				}
-			case "ctrl+h":
+			switch {
+			case key.Matches(msg, Keys.ToggleImagePicker):
+				chat.pickingImage = true
+				return chat, nil
+			case key.Matches(msg, Keys.ToggleHelp):
 				chat.helpVisible = true
 				return chat, nil
-			case "ctrl+x":
-				chat.attachedImage = ""
+			case key.Matches(msg, Keys.RemoveAttachment):
+				chat.trayFocused = len(chat.attachments) > 0
 				return chat, nil
-			case "ctrl+p":
-				chat.highlightedChatIndex--
-				if chat.highlightedChatIndex < 0 {
-					chat.highlightedChatIndex = 0
-				}
-				chat.redrawViewport()
-				if chat.highlightedChatIndex == 0 {
-					chat.viewport.SetYOffset(0)
-				} else {
-					h := lipgloss.Height(
-						lipgloss.JoinVertical(
-							lipgloss.Left,
-							chat.chatState[:chat.highlightedChatIndex]...,
-						),
-					)
-					chat.viewport.SetYOffset(h)
+			case key.Matches(msg, Keys.Retry):
+				if cmd := chat.retry(); cmd != nil {
+					return chat, cmd
 				}
-			case "ctrl+n":
-				chat.highlightedChatIndex++
-				if chat.highlightedChatIndex >= len(chat.ChatHistory) {
-					chat.highlightedChatIndex = len(chat.ChatHistory) - 1
+			case key.Matches(msg, Keys.Regenerate):
+				if cmd := chat.regenerate(); cmd != nil {
+					return chat, cmd
 				}
-				chat.redrawViewport()
-				if chat.highlightedChatIndex == 0 {
-					chat.viewport.SetYOffset(0)
-				} else {
-					h := lipgloss.Height(
-						lipgloss.JoinVertical(
-							lipgloss.Left,
-							chat.chatState[:chat.highlightedChatIndex]...,
-						),
-					)
-					chat.viewport.SetYOffset(h)
+			case key.Matches(msg, Keys.EditMessage):
+				return chat, chat.toggleEditing()
+			case key.Matches(msg, Keys.Search):
+				return chat, chat.openSearch()
+			case key.Matches(msg, Keys.CompleteCommand):
+				return chat, chat.completeCommand()
+			case key.Matches(msg, Keys.SwitchModel):
+				return chat, chat.pickModel()
+			case key.Matches(msg, Keys.Settings):
+				return chat, chat.openSettings()
+			case key.Matches(msg, Keys.Compact):
+				return chat, chat.compact()
+			case key.Matches(msg, Keys.OpenEditor):
+				return chat, chat.openEditor()
+			case key.Matches(msg, Keys.CodeBlocks):
+				return chat, chat.openCodeBlocks()
+			case key.Matches(msg, Keys.ToggleCollapsed):
+				chat.toggleCollapsed()
+				return chat, nil
+			case key.Matches(msg, Keys.DeleteMessage):
+				if len(chat.ChatHistory) > 0 {
+					chat.confirmingDelete = true
 				}
-			case "ctrl+u":
+				return chat, nil
+			case key.Matches(msg, Keys.PreviousAlternative):
+				return chat, chat.cycleAlternative(-1)
+			case key.Matches(msg, Keys.NextAlternative):
+				return chat, chat.cycleAlternative(1)
+			case key.Matches(msg, Keys.HighlightPreviousMessage):
+				chat.highlightMessage(chat.highlightedChatIndex - 1)
+			case key.Matches(msg, Keys.HighlightNextMessage):
+				chat.highlightMessage(chat.highlightedChatIndex + 1)
+			case key.Matches(msg, Keys.HalfPageUp):
 				chat.viewport.HalfViewUp()
-			case "ctrl+d":
+			case key.Matches(msg, Keys.HalfPageDown):
 				chat.viewport.HalfViewDown()
-			case "ctrl+up":
+			case key.Matches(msg, Keys.Up):
 				chat.viewport.LineUp(1)
-			case "ctrl+down":
+			case key.Matches(msg, Keys.Down):
 				chat.viewport.LineDown(1)
-			case "alt+y":
+			case key.Matches(msg, Keys.CopyHighlightedMessage):
 				cmd := chat.CopyToClipboard(
 					chat.ChatHistory[chat.highlightedChatIndex].Message,
 					CopyHighlighted,
 				)
 				cmds = append(cmds, cmd)
 				return chat, tea.Batch(cmds...)
-			case "ctrl+y":
+			case key.Matches(msg, Keys.CopyLastResponse):
 				cmd := chat.CopyToClipboard(
 					chat.ChatHistory[len(chat.ChatHistory)-1].Message,
 					CopyLastResponse,
@@ -875,6 +1625,9 @@ func (chat *Chat) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 		chat.viewport, cmd = chat.viewport.Update(msg)
 		cmds = append(cmds, cmd)
 		if msg.Action == tea.MouseActionRelease && msg.Button == tea.MouseButtonLeft {
+			if chat.handleTrayClick(msg) {
+				return chat, tea.Batch(cmds...)
+			}
 			for idx := range chat.ChatHistory {
 				// Check each item to see if it's in bounds.
 				if zone.Get(fmt.Sprintf("%d", idx)).InBounds(msg) {
@@ -892,30 +1645,81 @@ func (chat *Chat) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 		}
 
 		return chat, tea.Batch(cmds...)
+	case bubblesRenderedMsg:
+		return chat, chat.applyRenderedBubbles(msg)
+	case vimTimeoutMsg:
+		return chat, chat.finishVimSequence(msg)
+	case ImageRenderedMsg:
+		chat.finishImage(msg)
+		return chat, nil
+	case streamMsg:
+		// ignore the messages of a stream that was stopped, they might arrive
+		// once the next stream started
+		if msg.stream != chat.streamID {
+			return chat, nil
+		}
+		return chat.Update(msg.msg)
 	case StreamChunk:
+		// ignore chunks that arrive after the stream was stopped
+		if !chat.streaming {
+			return chat, nil
+		}
 		// update the last message in the chat history
 		chat.ChatHistory[len(chat.ChatHistory)-1].Message += string(msg)
-		chat.chatState[len(chat.chatState)-1] = chat.getMessageBubble(chat.ChatHistory[len(chat.ChatHistory)-1], false, fmt.Sprintf("%d", len(chat.ChatHistory)-1))
-		chat.updateViewport()
+		return chat, chat.scheduleRepaint()
+	case streamRepaintMsg:
+		chat.repaintPending = false
+		// the stream might have finished before the frame was due
+		if chat.streaming {
+			chat.repaintStream()
+		}
+		return chat, nil
+	case ModelsMsg:
+		chat.models = msg
+		return chat, nil
+	case ContextLengthMsg:
+		// the model might have been switched while fetching
+		if msg.Model == chat.modelName {
+			chat.modelContext = msg
+		}
 		return chat, nil
 	case clearNotificationMsg:
 		chat.notification = ""
 		chat.notificationVisible = false
 		return chat, nil
+	case StreamToolCalls:
+		if chat.streaming {
+			last := chat.ChatHistory[len(chat.ChatHistory)-1]
+			last.ToolCalls = append(last.ToolCalls, msg...)
+		}
+		return chat, nil
+	case ToolResultMsg:
+		return chat, chat.finishToolCall(msg)
+	case MCPServerMsg:
+		if msg.Exited {
+			return chat, chat.exitMCPServer(msg)
+		}
+		return chat, chat.finishMCPServer(msg)
 	case FinishedStreaming:
-		chat.streaming = false
-		chat.chatState[len(chat.chatState)-1] = chat.getMessageBubble(chat.ChatHistory[len(chat.ChatHistory)-1], false, fmt.Sprintf("%d", len(chat.ChatHistory)-1))
-		chat.updateViewport()
-		chat.attachedImage = ""
-		return chat, tea.Batch(
-			chat.resetPrompt(
-				purple,
-				cream,
-				"Type your message here...",
-				HighlightForegroundStyle,
-				true,
-			)...,
-		)
+		if chat.isCompacting() {
+			return chat, chat.finishCompacting()
+		}
+		if last := chat.ChatHistory[len(chat.ChatHistory)-1]; len(last.ToolCalls) > 0 {
+			return chat, chat.startToolCalls()
+		}
+		return chat, tea.Batch(chat.finishStreaming(), chat.autoCompact())
+	case StreamMetrics:
+		if chat.streaming {
+			metrics := MessageMetrics(msg)
+			chat.ChatHistory[len(chat.ChatHistory)-1].Metrics = &metrics
+		}
+		return chat, nil
+	case StreamError:
+		return chat, chat.failStreaming(msg.Err)
+	case EditorFinishedMsg:
+		return chat, chat.finishEditor(msg)
+	case ExecFinishedMsg:
+		return chat, chat.finishExec(msg)
 	}
 
 	isKeyMsg := false
@@ -931,26 +1735,55 @@ func (chat *Chat) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 			cmds = append(cmds, cmd)
 		}
 	}
+	// the settings panel's form gets the messages meant for huh forms instead
+	// of the prompt (they are not scoped to a single form)
+	if chat.settingsForm != nil {
+		cmds = append(cmds, chat.updateSettings(msg))
+		return chat, tea.Batch(cmds...)
+	}
+
 	if chat.pickingImage {
 		if didSelect, path := chat.imagepicker.DidSelectFile(msg); didSelect {
 			// Get the path of the selected file.
 			chat.pickingImage = false
-			chat.attachedImage = path
+			cmds = append(cmds, chat.addAttachment(path))
 			return chat, tea.Batch(cmds...)
 		}
-	} else if !chat.streaming {
+	} else if !chat.streaming && !(isKeyMsg && chat.normalMode) {
 		form, cmd := chat.promptForm.Update(msg)
 		if f, ok := form.(*huh.Form); ok {
 			chat.promptForm = f
 			cmds = append(cmds, cmd)
 		}
 
+		chat.resizePrompt()
+
 		if chat.promptForm.State == huh.StateCompleted {
 			prompt := chat.promptForm.GetString("message")
+			chat.draft = ""
+			chat.completions = nil
+
+			// slash commands are run locally, the command may replace the prompt
+			// (e.g. /retry disables it while streaming)
+			if strings.HasPrefix(strings.TrimSpace(prompt), "/") {
+				cmds = append(cmds, chat.focusPrompt())
+				if isCommand, cmd := chat.runCommand(prompt); isCommand {
+					return chat, tea.Batch(append(cmds, cmd)...)
+				}
+				prompt = strings.TrimPrefix(strings.TrimSpace(prompt), "/")
+			}
+
+			// an edited message is sent as an alternative of the original one,
+			// forking the conversation from that point
+			if chat.editing != nil {
+				chat.forkAt(chat.editing)
+				chat.editing = nil
+			}
+
 			streamCmd := chat.sendMessage(prompt, roles.USER)
 			resetChatCmd := chat.resetPrompt(
-				gray,
-				black,
+				mutedColor,
+				contrastColor,
 				"Disabled while response is being streamed...",
 				DisabledHighlightStyle,
 				false,
@@ -963,37 +1796,40 @@ func (chat *Chat) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 	return chat, tea.Batch(cmds...)
 }
 
-func (chat *Chat) getAttachedImageView() string {
-	if chat.attachedImage == "" {
-		return ""
-	}
-
-	return lipgloss.NewStyle().Width(chat.width).AlignHorizontal(lipgloss.Center).Render(
-		HighlightActiveStyle.Render(
-			fmt.Sprintf("󰁦 %s ", chat.attachedImage),
-		),
-	)
-}
-
 func (chat *Chat) View() string {
 	if chat.pickingImage {
 		return chat.ImagePickerView()
 	}
 
+	helpView := chat.textAreaHelpView()
+	if len(chat.completions) > 0 && chat.draft == chat.lastCompletion {
+		helpView = chat.completionsView()
+	}
+	if chat.searching || chat.searchQuery != "" {
+		helpView = chat.searchView()
+	}
+
+	// the context usage is shown on the right of the help line
+	gauge := chat.mcpStatusView() + chat.contextGaugeView()
+	if gap := chat.width - lipgloss.Width(helpView) - lipgloss.Width(gauge); gap > 0 {
+		helpView += strings.Repeat(" ", gap) + gauge
+	}
+
 	content := lipgloss.JoinVertical(
 		lipgloss.Left,
 		RoundedBorder.Render(chat.viewport.View()),
-		chat.getAttachedImageView(),
+		chat.attachmentTrayView(),
 		chat.promptForm.View(),
-		chat.textAreaHelpView(),
+		helpView,
 	)
 
 	if chat.helpVisible {
-
-		if chat.isMultiModal {
-			Keys.SetFullHelpKeys(Keys.DefaultFullHelpKeys())
-		} else {
-			Keys.SetFullHelpKeys(Keys.DefaultFullHelpKeysNonMultiModal())
+		Keys.SetFullHelpKeys(Keys.DefaultFullHelpKeys())
+		if config.Current.VimMode {
+			Keys.SetFullHelpKeys(append(
+				Keys.FullHelpKeys,
+				append([]key.Binding{Keys.NormalMode}, NormalKeys.help()...),
+			))
 		}
 		// chat.help.ShowAll = true
 
@@ -1004,18 +1840,42 @@ func (chat *Chat) View() string {
 				Width(8*chat.width/10).
 				Height(8*chat.height/10).
 				AlignHorizontal(lipgloss.Center).
-				BorderForeground(purple).
+				BorderForeground(primaryColor).
 				Render(
 					HighlightStyle.Render(" Help Menu ")+
 						"\n\n"+
 						chat.help.View(Keys)+
 						"\n\n"+
-						fmt.Sprintf("Press %s to close this menu", HighlightStyle.Render(" ctrl+h ")),
+						HighlightStyle.Render(" Commands ")+
+						"\n\n"+
+						commandsHelpView()+
+						"\n\n"+
+						fmt.Sprintf("Press %s to close this menu", HighlightStyle.Render(" "+Keys.ToggleHelp.Help().Key+" ")),
 				),
 			content,
 		)
 	}
 
+	if chat.confirmingDelete {
+		content = chat.deleteOverlayView(content)
+	}
+
+	if chat.settingsForm != nil {
+		content = chat.settingsOverlayView(content)
+	}
+
+	if chat.pickingCodeBlock {
+		content = chat.codeBlocksOverlayView(content)
+	}
+
+	if chat.pendingExec != nil {
+		content = chat.execOverlayView(content)
+	}
+
+	if chat.pendingTool != nil {
+		content = chat.toolOverlayView(content)
+	}
+
 	if chat.notificationVisible {
 		content = utils.PlaceOverlay(
 			chat.width,
@@ -1024,12 +1884,12 @@ func (chat *Chat) View() string {
 				layoutStyle.
 					Border(lipgloss.RoundedBorder(), false, true, true).
 					Padding(1, 2).
-					BorderForeground(notif).
+					BorderForeground(noticeColor).
 					Render(
 						chat.notification,
 					),
 				NotificationStyle.Render(" Notification "),
-				notif,
+				noticeColor,
 				"",
 			),
 			content,
@@ -0,0 +1,316 @@
+package chat
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/charmbracelet/bubbles/key"
+	"github.com/charmbracelet/bubbles/textinput"
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+	"github.com/gaurav-gosain/gollama/internal/utils"
+	"github.com/muesli/reflow/truncate"
+)
+
+// A fenced code block of a message
+type CodeBlock struct {
+	Language string
+	Code     string
+}
+
+// the file extensions of common code block languages, used for the default
+// file name when saving a block
+var languageExtensions = map[string]string{
+	"bash":       "sh",
+	"shell":      "sh",
+	"zsh":        "sh",
+	"python":     "py",
+	"javascript": "js",
+	"typescript": "ts",
+	"rust":       "rs",
+	"ruby":       "rb",
+	"markdown":   "md",
+	"yaml":       "yml",
+	"golang":     "go",
+	"text":       "txt",
+	"plaintext":  "txt",
+	"console":    "txt",
+}
+
+// Returns the fenced (``` or ~~~) code blocks of the markdown, a block that is
+// never closed runs until the end of the message
+func extractCodeBlocks(markdown string) []CodeBlock {
+	blocks := []CodeBlock{}
+
+	var (
+		fence   string
+		current *CodeBlock
+		lines   []string
+	)
+
+	for _, line := range strings.Split(markdown, "\n") {
+		trimmed := strings.TrimSpace(line)
+
+		if current == nil {
+			if !strings.HasPrefix(trimmed, "```") && !strings.HasPrefix(trimmed, "~~~") {
+				continue
+			}
+
+			fenceLength := len(trimmed) - len(strings.TrimLeft(trimmed, trimmed[:1]))
+			fence = trimmed[:fenceLength]
+
+			info := strings.Fields(trimmed[fenceLength:])
+			current = &CodeBlock{}
+			if len(info) > 0 {
+				current.Language = strings.ToLower(info[0])
+			}
+			lines = nil
+			continue
+		}
+
+		// the closing fence is at least as long as the opening one
+		if strings.HasPrefix(trimmed, fence) && strings.Trim(trimmed, fence[:1]) == "" {
+			current.Code = strings.Join(lines, "\n")
+			blocks = append(blocks, *current)
+			current = nil
+			continue
+		}
+
+		lines = append(lines, line)
+	}
+
+	if current != nil {
+		current.Code = strings.Join(lines, "\n")
+		blocks = append(blocks, *current)
+	}
+
+	return blocks
+}
+
+// Returns the default file name for the code block
+func (block CodeBlock) fileName() string {
+	extension := block.Language
+	if ext, ok := languageExtensions[block.Language]; ok {
+		extension = ext
+	}
+	if extension == "" {
+		extension = "txt"
+	}
+
+	return "snippet." + extension
+}
+
+// Opens the code block picker for the highlighted message
+func (chat *Chat) openCodeBlocks() tea.Cmd {
+	if len(chat.ChatHistory) == 0 {
+		return nil
+	}
+
+	blocks := extractCodeBlocks(chat.ChatHistory[chat.highlightedChatIndex].Message)
+	if len(blocks) == 0 {
+		return chat.notify("The highlighted message has no code blocks")
+	}
+
+	chat.codeBlocks = blocks
+	chat.codeBlockIndex = 0
+	chat.pickingCodeBlock = true
+
+	return nil
+}
+
+// Closes the code block picker
+func (chat *Chat) closeCodeBlocks() {
+	chat.pickingCodeBlock = false
+	chat.savingCodeBlock = false
+	chat.overwritePath = ""
+	chat.codeBlocks = nil
+	chat.codeBlockInput.Blur()
+}
+
+// Handles the key presses while the code block picker is open
+func (chat *Chat) handleCodeBlockKeys(msg tea.KeyMsg) tea.Cmd {
+	block := chat.codeBlocks[chat.codeBlockIndex]
+
+	// confirming that an existing file should be overwritten
+	if chat.overwritePath != "" {
+		switch {
+		case key.Matches(msg, Keys.Confirm):
+			path := chat.overwritePath
+			chat.closeCodeBlocks()
+			return chat.saveCodeBlock(block, path)
+		case key.Matches(msg, Keys.Deny, Keys.Close):
+			chat.overwritePath = ""
+		}
+		return nil
+	}
+
+	// typing the path the block is saved to
+	if chat.savingCodeBlock {
+		switch {
+		case key.Matches(msg, Keys.Close):
+			chat.savingCodeBlock = false
+			chat.codeBlockInput.Blur()
+			return nil
+		case key.Matches(msg, Keys.Submit):
+			path := strings.TrimSpace(chat.codeBlockInput.Value())
+			if path == "" {
+				return nil
+			}
+
+			expandedPath, err := utils.ExpandPath(path)
+			if err != nil {
+				return chat.notify(err.Error())
+			}
+
+			if _, err := os.Stat(expandedPath); err == nil {
+				chat.overwritePath = expandedPath
+				return nil
+			}
+
+			chat.closeCodeBlocks()
+			return chat.saveCodeBlock(block, expandedPath)
+		}
+
+		var cmd tea.Cmd
+		chat.codeBlockInput, cmd = chat.codeBlockInput.Update(msg)
+		return cmd
+	}
+
+	if key.Matches(msg, Keys.Close, Keys.CodeBlocks) {
+		chat.closeCodeBlocks()
+		return nil
+	}
+
+	switch {
+	case key.Matches(msg, Keys.PreviousItem):
+		chat.codeBlockIndex = max(chat.codeBlockIndex-1, 0)
+	case key.Matches(msg, Keys.NextItem):
+		chat.codeBlockIndex = min(chat.codeBlockIndex+1, len(chat.codeBlocks)-1)
+	case key.Matches(msg, Keys.SelectCodeBlock):
+		// the nth key of the binding selects the nth block
+		if index := slices.Index(Keys.SelectCodeBlock.Keys(), msg.String()); index < len(chat.codeBlocks) {
+			chat.codeBlockIndex = index
+		}
+	case key.Matches(msg, Keys.CopyCodeBlock):
+		chat.closeCodeBlocks()
+		return chat.CopyToClipboard(block.Code, CopyCodeBlock)
+	case key.Matches(msg, Keys.RunCodeBlock):
+		chat.closeCodeBlocks()
+		return chat.requestExec(block)
+	case key.Matches(msg, Keys.SaveCodeBlock):
+		chat.savingCodeBlock = true
+		chat.codeBlockInput.SetValue(block.fileName())
+		chat.codeBlockInput.CursorEnd()
+		return chat.codeBlockInput.Focus()
+	case key.Matches(msg, Keys.Deny):
+		chat.closeCodeBlocks()
+	}
+
+	return nil
+}
+
+// Writes the code block to the file
+func (chat *Chat) saveCodeBlock(block CodeBlock, path string) tea.Cmd {
+	code := block.Code
+	if !strings.HasSuffix(code, "\n") {
+		code += "\n"
+	}
+
+	if err := os.WriteFile(path, []byte(code), 0o644); err != nil { //nolint:mnd
+		return chat.notify(fmt.Sprintf("Could not save the code block: %v", err))
+	}
+
+	return chat.notify("Saved the code block to " + path)
+}
+
+// Creates the text input used for the path a code block is saved to
+func newCodeBlockInput() textinput.Model {
+	input := textinput.New()
+	input.Prompt = "Save to: "
+	input.PromptStyle = HighlightForegroundStyle
+	return input
+}
+
+// Renders the code block picker on top of the provided content
+func (chat *Chat) codeBlocksOverlayView(content string) string {
+	width := max(30, chat.width/2)
+
+	items := []string{}
+	for i, block := range chat.codeBlocks {
+		language := block.Language
+		if language == "" {
+			language = "text"
+		}
+
+		lines := strings.Count(block.Code, "\n") + 1
+		preview := strings.TrimSpace(strings.SplitN(strings.TrimSpace(block.Code), "\n", 2)[0])
+
+		label := fmt.Sprintf("%d %s (%d lines)", i+1, language, lines)
+		style := HighlightStyle
+		if i == chat.codeBlockIndex {
+			style = HighlightActiveStyle
+		}
+
+		items = append(items, lipgloss.JoinHorizontal(
+			lipgloss.Left,
+			style.Render(label),
+			" ",
+			lipgloss.NewStyle().Foreground(mutedColor).Render(
+				truncate.StringWithTail(preview, uint(max(0, width-lipgloss.Width(label)-8)), "…"),
+			),
+		))
+	}
+
+	hints := []string{
+		keyHint("select", Keys.PreviousItem, Keys.NextItem),
+		keyHint("copy", Keys.CopyCodeBlock),
+		keyHint("save to file", Keys.SaveCodeBlock),
+	}
+	if chat.codeBlocks[chat.codeBlockIndex].runnable() {
+		hints = append(hints, keyHint("run", Keys.RunCodeBlock))
+	}
+	footer := keyHintsView(append(hints, keyHint("close", Keys.Close))...)
+	switch {
+	case chat.overwritePath != "":
+		footer = lipgloss.JoinVertical(
+			lipgloss.Left,
+			fmt.Sprintf("%s already exists, overwrite it?", filepath.Base(chat.overwritePath)),
+			keyHintsView(keyHint("overwrite", Keys.Confirm), keyHint("cancel", Keys.Deny)),
+		)
+	case chat.savingCodeBlock:
+		footer = lipgloss.JoinVertical(
+			lipgloss.Left,
+			chat.codeBlockInput.View(),
+			keyHintsView(keyHint("save", Keys.Submit), keyHint("cancel", Keys.Close)),
+		)
+	}
+
+	overlay := addToBorder(
+		layoutStyle.
+			Border(lipgloss.RoundedBorder(), false, true, true).
+			Width(width).
+			Padding(1, 2).
+			BorderForeground(primaryColor).
+			Render(
+				lipgloss.JoinVertical(
+					lipgloss.Left,
+					lipgloss.JoinVertical(lipgloss.Left, items...),
+					"",
+					footer,
+				),
+			),
+		HighlightStyle.Render(" Code Blocks "),
+		primaryColor,
+		"",
+	)
+
+	return utils.PlaceOverlay(
+		(chat.width-lipgloss.Width(overlay))/2,
+		(chat.height-lipgloss.Height(overlay))/2,
+		overlay,
+		content,
+	)
+}
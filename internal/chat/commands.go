@@ -0,0 +1,487 @@
+package chat
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"unicode/utf8"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+	"github.com/gaurav-gosain/gollama/internal/client"
+	"github.com/gaurav-gosain/gollama/internal/config"
+	"github.com/gaurav-gosain/gollama/internal/roles"
+	"github.com/gaurav-gosain/gollama/internal/utils"
+	oapi "github.com/ollama/ollama/api"
+)
+
+// A command that can be typed in the prompt (e.g. /model llama3.2), commands
+// are handled locally and never sent to the model
+type SlashCommand struct {
+	Name        string
+	Args        string
+	Description string
+	run         func(chat *Chat, args string) tea.Cmd
+	complete    func(chat *Chat, arg string) []string
+}
+
+// ModelsMsg holds the locally installed models
+type ModelsMsg []oapi.ListModelResponse
+
+var SlashCommands = []SlashCommand{
+	{
+		Name:        "/model",
+		Args:        "<name>",
+		Description: "Switch the model of the chat",
+		run:         (*Chat).modelCommand,
+		complete: func(chat *Chat, arg string) []string {
+			return filterPrefix(chat.modelNames(), arg)
+		},
+	},
+	{
+		Name:        "/system",
+		Args:        "<text>",
+		Description: "Set the system message (empty to remove it)",
+		run:         (*Chat).systemCommand,
+	},
+	{
+		Name:        "/clear",
+		Description: "Delete every message of the chat",
+		run:         (*Chat).clearCommand,
+	},
+	{
+		Name:        "/retry",
+		Description: "Resend the last message",
+		run: func(chat *Chat, _ string) tea.Cmd {
+			if chat.streamErr != nil {
+				return chat.retry()
+			}
+			return chat.regenerate()
+		},
+	},
+	{
+		Name:        "/compact",
+		Description: "Summarize the older messages to free up the context window",
+		run: func(chat *Chat, _ string) tea.Cmd {
+			return chat.compact()
+		},
+	},
+	{
+		Name:        "/export",
+		Args:        "<path>",
+		Description: "Export the conversation as markdown",
+		run:         (*Chat).exportCommand,
+		complete: func(_ *Chat, arg string) []string {
+			return completePath(arg)
+		},
+	},
+	{
+		Name:        "/title",
+		Args:        "<name>",
+		Description: "Rename the chat",
+		run:         (*Chat).titleCommand,
+	},
+	{
+		Name:        "/theme",
+		Args:        "<name>",
+		Description: "Switch the theme (auto follows the terminal background)",
+		run:         (*Chat).themeCommand,
+		complete: func(_ *Chat, arg string) []string {
+			return filterPrefix(config.ThemeNames(), arg)
+		},
+	},
+	{
+		Name:        "/mcp",
+		Args:        "[restart [name]]",
+		Description: "Show the status of the MCP servers (or restart them)",
+		run:         (*Chat).mcpCommand,
+		complete: func(_ *Chat, arg string) []string {
+			return filterPrefix([]string{"restart"}, arg)
+		},
+	},
+	{
+		Name:        "/help",
+		Description: "Show the help menu",
+		run: func(chat *Chat, _ string) tea.Cmd {
+			chat.helpVisible = true
+			return nil
+		},
+	},
+}
+
+// Fetches the installed models (used for completion and switching models)
+func fetchModels() tea.Msg {
+	models, err := client.GollamaInstance.API.Client.List(context.Background())
+	if err != nil {
+		return nil
+	}
+
+	sort.Slice(models.Models, func(i, j int) bool {
+		return models.Models[i].Name < models.Models[j].Name
+	})
+
+	return ModelsMsg(models.Models)
+}
+
+// Returns the names of the installed models
+func (chat *Chat) modelNames() []string {
+	names := []string{}
+	for _, model := range chat.models {
+		names = append(names, model.Name)
+	}
+	return names
+}
+
+// Returns the installed model with the provided name (the :latest tag is
+// optional, as in the Ollama CLI)
+func (chat *Chat) findModel(name string) (oapi.ListModelResponse, bool) {
+	for _, model := range chat.models {
+		if model.Name == name || model.Name == name+":latest" {
+			return model, true
+		}
+	}
+	return oapi.ListModelResponse{}, false
+}
+
+// Returns the command the prompt starts with (nil if it isn't a command) and
+// its arguments, a prompt starting with // is sent as a regular message
+func parseCommand(prompt string) (*SlashCommand, string, bool) {
+	prompt = strings.TrimSpace(prompt)
+	if !strings.HasPrefix(prompt, "/") || strings.HasPrefix(prompt, "//") {
+		return nil, "", false
+	}
+
+	name, args, _ := strings.Cut(prompt, " ")
+	for i := range SlashCommands {
+		if SlashCommands[i].Name == name {
+			return &SlashCommands[i], strings.TrimSpace(args), true
+		}
+	}
+
+	return nil, name, true
+}
+
+// Runs the command in the prompt, returns false if the prompt is a message
+func (chat *Chat) runCommand(prompt string) (bool, tea.Cmd) {
+	command, args, isCommand := parseCommand(prompt)
+	if !isCommand {
+		return false, nil
+	}
+
+	if command == nil {
+		return true, chat.notify(fmt.Sprintf(
+			"Unknown command %s (start the message with // to send it as is)",
+			args,
+		))
+	}
+
+	return true, command.run(chat, args)
+}
+
+// Completes the command name (or its argument) in the prompt, repeated
+// completions cycle through the candidates
+func (chat *Chat) completeCommand() tea.Cmd {
+	if !strings.HasPrefix(chat.draft, "/") || strings.Contains(chat.draft, "\n") {
+		return nil
+	}
+
+	// keep cycling through the previous candidates
+	if len(chat.completions) > 1 && chat.draft == chat.lastCompletion {
+		chat.completionIndex = (chat.completionIndex + 1) % len(chat.completions)
+		chat.setDraft(chat.completionPrefix + chat.completions[chat.completionIndex])
+		return chat.focusPrompt()
+	}
+
+	prefix, token := "", chat.draft
+	candidates := []string{}
+
+	if name, arg, hasArg := strings.Cut(chat.draft, " "); hasArg {
+		command, _, _ := parseCommand(name)
+		if command == nil || command.complete == nil {
+			return nil
+		}
+		prefix, token = name+" ", strings.TrimLeft(arg, " ")
+		candidates = command.complete(chat, token)
+	} else {
+		for _, command := range SlashCommands {
+			if strings.HasPrefix(command.Name, token) {
+				candidate := command.Name
+				if command.Args != "" {
+					candidate += " "
+				}
+				candidates = append(candidates, candidate)
+			}
+		}
+	}
+
+	chat.completions = candidates
+	chat.completionIndex = 0
+	chat.completionPrefix = prefix
+
+	switch len(candidates) {
+	case 0:
+		return nil
+	case 1:
+		chat.setDraft(prefix + candidates[0])
+		chat.completions = nil
+	default:
+		chat.completionIndex = -1
+		chat.setDraft(prefix + commonPrefix(candidates))
+		if chat.draft == prefix+token {
+			// nothing to extend, start cycling through the candidates
+			chat.completionIndex = 0
+			chat.setDraft(prefix + candidates[0])
+		}
+	}
+
+	return chat.focusPrompt()
+}
+
+// Replaces the prompt's content, remembering it to detect repeated completions
+func (chat *Chat) setDraft(draft string) {
+	chat.draft = draft
+	chat.lastCompletion = draft
+}
+
+// Recreates the (focused) prompt so it picks up the current draft
+func (chat *Chat) focusPrompt() tea.Cmd {
+	return tea.Batch(
+		chat.resetPrompt(
+			primaryColor,
+			foregroundColor,
+			"Type your message here...",
+			HighlightForegroundStyle,
+			true,
+		)...,
+	)
+}
+
+// Renders the completion candidates in place of the help line
+func (chat *Chat) completionsView() string {
+	candidates := make([]string, len(chat.completions))
+	for i, candidate := range chat.completions {
+		candidate = strings.TrimSpace(candidate)
+		if i == chat.completionIndex && chat.draft == chat.lastCompletion {
+			candidate = HighlightForegroundStyle.Render(candidate)
+		}
+		candidates[i] = candidate
+	}
+
+	return helpStyle("tab ") + strings.Join(candidates, helpStyle(" • "))
+}
+
+// Renders the list of commands (shown in the help menu)
+func commandsHelpView() string {
+	rows := []string{}
+	for _, command := range SlashCommands {
+		rows = append(rows, lipgloss.JoinHorizontal(
+			lipgloss.Left,
+			lipgloss.NewStyle().
+				Width(18).
+				Foreground(helpKeyColor).
+				Render(strings.TrimSpace(command.Name+" "+command.Args)),
+			command.Description,
+		))
+	}
+
+	return lipgloss.JoinVertical(lipgloss.Left, rows...)
+}
+
+func (chat *Chat) modelCommand(name string) tea.Cmd {
+	if name == "" {
+		return chat.notify("Usage: /model <name>")
+	}
+
+	return chat.switchModel(name)
+}
+
+// Switches the model used for the next responses and saves it as the chat's
+// current model, the previous responses keep the model that wrote them
+func (chat *Chat) switchModel(name string) tea.Cmd {
+	if len(chat.models) > 0 {
+		model, ok := chat.findModel(name)
+		if !ok {
+			return chat.notify(fmt.Sprintf("Model %s is not installed", name))
+		}
+
+		// same check as the model picker (ollamanager) uses
+		chat.isMultiModal = len(model.Details.Families) > 1
+	}
+
+	chat.modelName = name
+	chat.ChatSettings.ModelName = name
+	chat.ChatSettings.IsMultiModal = chat.isMultiModal
+
+	if err := chat.saveSettings(); err != nil {
+		return chat.notify(err.Error())
+	}
+
+	// the prompt's title shows the current model
+	return tea.Batch(
+		chat.focusPrompt(),
+		chat.notify("Switched model to "+name),
+		fetchContextLength(name),
+	)
+}
+
+// Fills the prompt with the /model command and lists the installed models
+func (chat *Chat) pickModel() tea.Cmd {
+	chat.setDraft("/model ")
+	chat.completions = nil
+	return chat.completeCommand()
+}
+
+func (chat *Chat) systemCommand(message string) tea.Cmd {
+	chat.ChatSettings.SystemMessage = message
+
+	if err := chat.saveSettings(); err != nil {
+		return chat.notify(err.Error())
+	}
+
+	if message == "" {
+		return chat.notify("Removed the system message")
+	}
+	return chat.notify("Updated the system message")
+}
+
+func (chat *Chat) titleCommand(title string) tea.Cmd {
+	if title == "" {
+		return chat.notify("Usage: /title <name>")
+	}
+
+	chat.ChatSettings.ChatTitle = title
+
+	if err := chat.saveSettings(); err != nil {
+		return chat.notify(err.Error())
+	}
+	return chat.notify("Renamed the chat to " + title)
+}
+
+// Asks to confirm deleting every message of the chat
+func (chat *Chat) clearCommand(_ string) tea.Cmd {
+	if len(chat.ChatHistory) == 0 {
+		return chat.notify("The chat is already empty")
+	}
+
+	chat.confirmingDelete = true
+	chat.confirmingClear = true
+	return nil
+}
+
+func (chat *Chat) exportCommand(path string) tea.Cmd {
+	if path == "" {
+		return chat.notify("Usage: /export <path>")
+	}
+
+	expandedPath, err := utils.ExpandPath(path)
+	if err != nil {
+		return chat.notify(err.Error())
+	}
+
+	if err := os.WriteFile(expandedPath, []byte(chat.exportMarkdown()), 0o644); err != nil { //nolint:mnd
+		return chat.notify(fmt.Sprintf("Could not export the chat: %v", err))
+	}
+
+	return chat.notify("Exported the chat to " + path)
+}
+
+// Writes the settings of the chat to the database (anonymous chats are never
+// saved)
+func (chat *Chat) saveSettings() error {
+	if chat.ChatSettings.IsAnonymous {
+		return nil
+	}
+
+	return client.GollamaInstance.UpdateChat(chat.ChatSettings)
+}
+
+// Formats the active conversation as a markdown document
+func (chat *Chat) exportMarkdown() string {
+	var b strings.Builder
+
+	title := chat.ChatSettings.ChatTitle
+	if title == "" {
+		title = "Chat with " + chat.modelName
+	}
+	fmt.Fprintf(&b, "# %s\n\n", title)
+
+	if system := strings.TrimSpace(chat.ChatSettings.SystemMessage); system != "" {
+		fmt.Fprintf(&b, "## %s\n\n%s\n\n", roles.SYSTEM, system)
+	}
+
+	for _, msg := range chat.ChatHistory {
+		author := msg.Role
+		if msg.Role == roles.ASSISTANT {
+			author = chat.messageModel(msg)
+		}
+
+		fmt.Fprintf(&b, "## %s\n\n", author)
+		for _, img := range msg.Images {
+			fmt.Fprintf(&b, "![%s](%s)\n\n", filepath.Base(img), img)
+		}
+		for _, file := range msg.Files {
+			fmt.Fprintf(&b, "> Attached file: `%s`\n\n", file.Path)
+		}
+		fmt.Fprintf(&b, "%s\n\n", strings.TrimSpace(msg.Message))
+	}
+
+	return b.String()
+}
+
+// Returns the values starting with the provided prefix
+func filterPrefix(values []string, prefix string) []string {
+	filtered := []string{}
+	for _, value := range values {
+		if strings.HasPrefix(value, prefix) {
+			filtered = append(filtered, value)
+		}
+	}
+	return filtered
+}
+
+// Returns the longest common prefix of the values, it is trimmed by runes so
+// a multi-byte character is never cut in half
+func commonPrefix(values []string) string {
+	if len(values) == 0 {
+		return ""
+	}
+
+	prefix := values[0]
+	for _, value := range values[1:] {
+		for !strings.HasPrefix(value, prefix) {
+			_, size := utf8.DecodeLastRuneInString(prefix)
+			prefix = prefix[:len(prefix)-size]
+		}
+	}
+
+	return prefix
+}
+
+// Returns the paths starting with the provided (partial) path, directories
+// end with a path separator
+func completePath(partial string) []string {
+	expanded, err := utils.ExpandPath(partial)
+	if err != nil {
+		return nil
+	}
+
+	matches, err := filepath.Glob(expanded + "*")
+	if err != nil {
+		return nil
+	}
+
+	candidates := []string{}
+	for _, match := range matches {
+		// keep the ~ typed by the user
+		candidate := partial + strings.TrimPrefix(match, expanded)
+		if info, err := os.Stat(match); err == nil && info.IsDir() {
+			candidate += string(filepath.Separator)
+		}
+		candidates = append(candidates, candidate)
+	}
+
+	return candidates
+}
@@ -0,0 +1,246 @@
+package chat
+
+import (
+	"fmt"
+	"strings"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/gaurav-gosain/gollama/internal/roles"
+	"github.com/muesli/reflow/truncate"
+	oapi "github.com/ollama/ollama/api"
+	uuid "github.com/satori/go.uuid"
+)
+
+// the number of messages at the end of the conversation that are sent as is
+// instead of being summarized (the last exchange)
+const compactKeepMessages = 2
+
+// the instruction sent after the messages to summarize
+const compactPrompt = `Summarize the conversation above so it can replace it as the context of the rest of the conversation.
+Keep every fact, decision, name, number, file, code snippet and open question that might be needed later, and leave out greetings and small talk.
+Write the summary in the language of the conversation and reply with the summary only.`
+
+// Returns the index of the last summary in the conversation (-1 if it was
+// never compacted)
+func lastSummary(history []*ChatNode) int {
+	for i := len(history) - 1; i >= 0; i-- {
+		if history[i].Summary {
+			return i
+		}
+	}
+	return -1
+}
+
+// Returns the messages of the conversation that were compacted into one of its
+// summaries (they are shown collapsed and never sent). The messages are
+// marked with the summary, so they are sent again once the summary is deleted
+// or the conversation is forked before it
+func compactedMessages(history []*ChatNode) map[*ChatNode]bool {
+	summaries := map[string]bool{}
+	for _, msg := range history {
+		if msg.Summary && msg.SummaryID != "" {
+			summaries[msg.SummaryID] = true
+		}
+	}
+
+	compacted := map[*ChatNode]bool{}
+	for _, msg := range history {
+		if msg.CompactedBy != "" && summaries[msg.CompactedBy] {
+			compacted[msg] = true
+		}
+	}
+
+	return compacted
+}
+
+// Returns the messages of the conversation the model still needs: the last
+// summary followed by the messages it doesn't cover
+func uncompactedHistory(history []*ChatNode) []*ChatNode {
+	summary := lastSummary(history)
+	if summary < 0 {
+		return history
+	}
+
+	compacted := compactedMessages(history)
+	messages := []*ChatNode{history[summary]}
+	for i, msg := range history {
+		if i != summary && !compacted[msg] {
+			messages = append(messages, msg)
+		}
+	}
+
+	return messages
+}
+
+// Converts a summary to the message sent in its place
+func summaryMessage(summary *ChatNode) oapi.Message {
+	return oapi.Message{
+		Role:    roles.SYSTEM,
+		Content: "Summary of the earlier conversation:\n\n" + summary.Message,
+	}
+}
+
+// Asks the model to summarize the conversation (except for the last exchange),
+// the summary replaces the summarized messages in the following requests
+func (chat *Chat) compact() tea.Cmd {
+	last := len(chat.ChatHistory) - 1
+	if last < 0 ||
+		chat.ChatHistory[last].Role != roles.ASSISTANT ||
+		chat.ChatHistory[last].Summary {
+		return chat.notify("Nothing to compact yet")
+	}
+
+	history := uncompactedHistory(chat.ChatHistory)
+	covered := history[:max(0, len(history)-compactKeepMessages)]
+
+	// compacting a summary on its own wouldn't save anything
+	count := 0
+	for _, msg := range covered {
+		if !msg.Summary {
+			count++
+		}
+	}
+	if count < 2 {
+		return chat.notify("Nothing to compact yet")
+	}
+
+	messages := []oapi.Message{}
+	if strings.TrimSpace(chat.ChatSettings.SystemMessage) != "" {
+		messages = append(messages, oapi.Message{
+			Role:    roles.SYSTEM,
+			Content: chat.ChatSettings.SystemMessage,
+		})
+	}
+
+	for _, msg := range covered {
+		if msg.Summary {
+			messages = append(messages, summaryMessage(msg))
+			continue
+		}
+		messages = append(messages, oapi.Message{
+			Role:    msg.Role,
+			Content: msg.requestContent(),
+		})
+	}
+
+	messages = append(messages, oapi.Message{
+		Role:    roles.USER,
+		Content: compactPrompt,
+	})
+
+	_, chat.compactTokens, _ = chat.contextMessages(chat.ChatHistory)
+
+	chat.streamErr = nil
+	streamCmd := chat.streamRequest(messages, nil, nil)
+
+	summary := chat.ChatHistory[len(chat.ChatHistory)-1]
+	summary.Summary = true
+	summary.SummaryID = uuid.Must(uuid.NewV4(), nil).String()
+	// a summary that fails is dropped, the messages are sent again then
+	for _, msg := range covered {
+		msg.CompactedBy = summary.SummaryID
+	}
+	chat.chatState[len(chat.chatState)-1] = chat.getMessageBubble(
+		summary,
+		false,
+		fmt.Sprintf("%d", len(chat.ChatHistory)-1),
+	)
+	chat.updateViewport()
+
+	resetChatCmd := chat.resetPrompt(
+		mutedColor,
+		contrastColor,
+		"Disabled while the conversation is being compacted...",
+		DisabledHighlightStyle,
+		false,
+	)
+
+	return tea.Batch(append(resetChatCmd, streamCmd)...)
+}
+
+// Compacts the conversation if the chat's auto compact threshold (a share of
+// the context window) is reached. It is not compacted again if the last
+// message is a summary or the last compaction didn't reduce the tokens, it
+// would be compacted on every turn
+func (chat *Chat) autoCompact() tea.Cmd {
+	last := len(chat.ChatHistory) - 1
+	if chat.ChatSettings.CompactAt <= 0 ||
+		chat.compactStalled ||
+		(last >= 0 && chat.ChatHistory[last].Summary) {
+		return nil
+	}
+
+	_, tokens, _ := chat.contextMessages(chat.ChatHistory)
+	if tokens*100 < chat.ChatSettings.CompactAt*chat.contextLength() {
+		return nil
+	}
+
+	return chat.compact()
+}
+
+// Completes the compaction once the summary is streamed, the summarized
+// messages are collapsed
+func (chat *Chat) finishCompacting() tea.Cmd {
+	summary := chat.ChatHistory[len(chat.ChatHistory)-1]
+	if strings.TrimSpace(summary.Message) == "" {
+		return chat.failCompacting(fmt.Errorf("the model returned an empty summary"))
+	}
+
+	cmd := chat.finishStreaming()
+
+	_, tokens, _ := chat.contextMessages(chat.ChatHistory)
+	chat.compactStalled = tokens >= chat.compactTokens
+
+	renderCmd := chat.renderChatState()
+	chat.updateViewport()
+
+	count := 0
+	for _, msg := range chat.ChatHistory {
+		if msg.CompactedBy == summary.SummaryID {
+			count++
+		}
+	}
+
+	return tea.Batch(
+		cmd,
+		renderCmd,
+		chat.notify(fmt.Sprintf(
+			"Compacted the conversation, the %d earlier messages are replaced by the summary",
+			count,
+		)),
+	)
+}
+
+// Drops a summary that couldn't be completed, a partial summary would lose
+// parts of the conversation
+func (chat *Chat) failCompacting(err error) tea.Cmd {
+	chat.popMessage(true)
+
+	cmd := chat.finishStreaming()
+	if err == nil {
+		return cmd
+	}
+
+	return tea.Batch(
+		cmd,
+		chat.notify("Could not compact the conversation: "+err.Error()),
+	)
+}
+
+// Returns true if a summary of the conversation is being streamed
+func (chat *Chat) isCompacting() bool {
+	return chat.streaming &&
+		len(chat.ChatHistory) > 0 &&
+		chat.ChatHistory[len(chat.ChatHistory)-1].Summary
+}
+
+// Returns true if the message was compacted into a summary
+func (chat *Chat) isCompacted(msg *ChatNode) bool {
+	return compactedMessages(chat.ChatHistory)[msg]
+}
+
+// Returns the first line of the message, shortened to fit a collapsed bubble
+func collapsedPreview(message string) string {
+	preview, _, _ := strings.Cut(strings.TrimSpace(message), "\n")
+	return truncate.StringWithTail(preview, 60, "…")
+}
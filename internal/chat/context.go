@@ -0,0 +1,314 @@
+package chat
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+	"github.com/gaurav-gosain/gollama/internal/client"
+	"github.com/gaurav-gosain/gollama/internal/roles"
+	oapi "github.com/ollama/ollama/api"
+)
+
+// The strategies used when the conversation doesn't fit in the context window
+const (
+	ContextStrategyNone    = "none"    // send everything, Ollama truncates the oldest tokens
+	ContextStrategySliding = "sliding" // drop the oldest messages until the request fits
+	ContextStrategyPinned  = "pinned"  // send the system message and the last N turns
+	ContextStrategyRefuse  = "refuse"  // don't send requests that don't fit
+)
+
+const (
+	// the context window Ollama uses when num_ctx is not set
+	defaultNumCtx = 2048
+	// the number of turns kept by the pinned strategy, unless configured
+	defaultKeepTurns = 8
+	// rough estimate of the tokens used by an image
+	imageTokens = 768
+	// tokens used by the chat template around every message
+	messageOverheadTokens = 4
+)
+
+// ContextLengthMsg holds the context window details of a model (from the Ollama
+// Show API)
+type ContextLengthMsg struct {
+	Model string
+	// the length of the context the model was trained with
+	MaxLength int
+	// the num_ctx parameter of the model's Modelfile (0 if not set)
+	NumCtx int
+}
+
+// Fetches the context length of the model from the Ollama Show API
+func fetchContextLength(model string) tea.Cmd {
+	return func() tea.Msg {
+		info, err := client.GollamaInstance.API.Client.Show(
+			context.Background(),
+			&oapi.ShowRequest{Model: model},
+		)
+		if err != nil {
+			return nil
+		}
+
+		msg := ContextLengthMsg{Model: model}
+
+		// e.g. llama.context_length
+		if arch, ok := info.ModelInfo["general.architecture"].(string); ok {
+			if length, ok := info.ModelInfo[arch+".context_length"].(float64); ok {
+				msg.MaxLength = int(length)
+			}
+		}
+
+		for _, line := range strings.Split(info.Parameters, "\n") {
+			fields := strings.Fields(line)
+			if len(fields) == 2 && fields[0] == "num_ctx" {
+				msg.NumCtx, _ = strconv.Atoi(fields[1])
+			}
+		}
+
+		return msg
+	}
+}
+
+// Returns the size (in tokens) of the context window used for the requests,
+// the chat's num_ctx takes precedence over the model's and is capped at the
+// length the model was trained with
+func (chat *Chat) contextLength() int {
+	length := defaultNumCtx
+
+	switch {
+	case chat.ChatSettings.NumCtx > 0:
+		length = chat.ChatSettings.NumCtx
+	case chat.modelContext.NumCtx > 0:
+		length = chat.modelContext.NumCtx
+	}
+
+	if chat.modelContext.MaxLength > 0 {
+		length = min(length, chat.modelContext.MaxLength)
+	}
+
+	return length
+}
+
+// Returns the options sent with every request
+func (chat *Chat) requestOptions() map[string]any {
+	if chat.ChatSettings.NumCtx <= 0 {
+		return nil
+	}
+
+	return map[string]any{
+		"num_ctx": chat.contextLength(),
+	}
+}
+
+// Estimates the number of tokens of the text (~4 characters per token)
+func estimateTokens(text string) int {
+	return (len(text) + 3) / 4
+}
+
+// Estimates the number of tokens the message uses in a request, the estimate
+// is kept until the message changes (its attached files are read again
+// otherwise)
+func estimateMessageTokens(msg *ChatNode) int {
+	if msg.tokens > 0 && msg.tokensLength == len(msg.Message) {
+		return msg.tokens
+	}
+
+	msg.tokens = messageOverheadTokens +
+		estimateTokens(msg.requestContent()) +
+		len(msg.Images)*imageTokens
+	msg.tokensLength = len(msg.Message)
+
+	return msg.tokens
+}
+
+// Returns the tokens kept free for the response when trimming the history
+func responseReserve(window int) int {
+	return min(1024, window/4)
+}
+
+// Applies the chat's context strategy to the history, returns the messages
+// that should be sent and their estimated token count (including the system
+// message), an error is returned if the request must not be sent
+func (chat *Chat) contextMessages(history []*ChatNode) ([]*ChatNode, int, error) {
+	window := chat.contextLength()
+	budget := window - responseReserve(window)
+
+	tokens := 0
+	if system := strings.TrimSpace(chat.ChatSettings.SystemMessage); system != "" {
+		tokens += messageOverheadTokens + estimateTokens(system)
+	}
+
+	// the summary of a compacted conversation is always sent, like the system
+	// message
+	var summary *ChatNode
+	history = uncompactedHistory(history)
+	if len(history) > 0 && history[0].Summary {
+		summary = history[0]
+		history = history[1:]
+		tokens += estimateMessageTokens(summary)
+	}
+
+	sizes := make([]int, len(history))
+	for i, msg := range history {
+		sizes[i] = estimateMessageTokens(msg)
+		tokens += sizes[i]
+	}
+
+	start := 0
+	var err error
+
+	switch chat.ChatSettings.ContextStrategy {
+	case ContextStrategySliding:
+		// keep at least the last message, even if it doesn't fit on its own
+		for tokens > budget && start < len(history)-1 {
+			tokens -= sizes[start]
+			start++
+		}
+	case ContextStrategyPinned:
+		keepTurns := chat.ChatSettings.KeepTurns
+		if keepTurns <= 0 {
+			keepTurns = defaultKeepTurns
+		}
+
+		turns := 0
+		for i := len(history) - 1; i >= 0; i-- {
+			if history[i].Role == roles.USER {
+				turns++
+			}
+			if turns > keepTurns {
+				break
+			}
+			start = i
+		}
+
+		for i := range history[:start] {
+			tokens -= sizes[i]
+		}
+	case ContextStrategyRefuse:
+		if tokens > budget {
+			err = fmt.Errorf(
+				"the request (~%d tokens) doesn't fit in the context window of %d tokens, delete or compact older messages, or change the context strategy in the chat settings",
+				tokens,
+				window,
+			)
+		}
+	}
+
+	// the conversation sent to the model should start with a user message
+	for start < len(history)-1 &&
+		(history[start].Role == roles.ASSISTANT || history[start].Role == roles.TOOL) {
+		tokens -= sizes[start]
+		start++
+	}
+
+	if summary != nil {
+		return append([]*ChatNode{summary}, history[start:]...), tokens, err
+	}
+
+	return history[start:], tokens, err
+}
+
+// The context usage gauge of the last frame, it is only rendered again once
+// the history, the prompt, the settings or the model change
+type contextGauge struct {
+	history   []gaugeMessage
+	draft     string
+	streaming bool
+	settings  client.Chat
+	model     ContextLengthMsg
+	view      string
+}
+
+// A message of the history the gauge was rendered for
+type gaugeMessage struct {
+	node   *ChatNode
+	length int
+}
+
+// Returns whether the gauge was rendered for the current state of the chat
+func (gauge *contextGauge) current(chat *Chat) bool {
+	if gauge.view == "" ||
+		gauge.draft != chat.draft ||
+		gauge.streaming != chat.streaming ||
+		gauge.settings != chat.ChatSettings ||
+		gauge.model != chat.modelContext ||
+		len(gauge.history) != len(chat.ChatHistory) {
+		return false
+	}
+
+	for i, node := range chat.ChatHistory {
+		if gauge.history[i].node != node || gauge.history[i].length != len(node.Message) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Renders the context usage gauge of the pending request (the history plus
+// the prompt being typed), the last gauge is reused until the chat changes
+func (chat *Chat) contextGaugeView() string {
+	if chat.gauge.current(chat) {
+		return chat.gauge.view
+	}
+
+	history := make([]gaugeMessage, len(chat.ChatHistory))
+	for i, node := range chat.ChatHistory {
+		history[i] = gaugeMessage{node: node, length: len(node.Message)}
+	}
+
+	chat.gauge = contextGauge{
+		history:   history,
+		draft:     chat.draft,
+		streaming: chat.streaming,
+		settings:  chat.ChatSettings,
+		model:     chat.modelContext,
+		view:      chat.renderContextGauge(),
+	}
+
+	return chat.gauge.view
+}
+
+// Renders the context usage gauge
+func (chat *Chat) renderContextGauge() string {
+	window := chat.contextLength()
+
+	sent, tokens, _ := chat.contextMessages(chat.ChatHistory)
+	if draft := strings.TrimSpace(chat.draft); draft != "" && !chat.streaming {
+		tokens += messageOverheadTokens + estimateTokens(draft)
+	}
+
+	ratio := min(1, float64(tokens)/float64(window))
+
+	const cells = 10
+	filled := int(ratio * cells)
+
+	color := activeColor
+	switch {
+	case tokens > window-responseReserve(window):
+		color = dangerColor
+	case ratio > 0.6:
+		color = noticeColor
+	}
+
+	label := fmt.Sprintf(" %s/%s tokens", formatTokens(tokens), formatTokens(window))
+	if dropped := len(chat.ChatHistory) - len(sent); dropped > 0 {
+		label += fmt.Sprintf(" (%d not sent)", dropped)
+	}
+
+	return lipgloss.NewStyle().Foreground(color).Render(
+		strings.Repeat("▰", filled)+strings.Repeat("▱", cells-filled),
+	) + helpStyle(label)
+}
+
+// Formats a token count (e.g. 1.2k)
+func formatTokens(tokens int) string {
+	if tokens < 1000 {
+		return strconv.Itoa(tokens)
+	}
+	return strconv.FormatFloat(float64(tokens)/1000, 'f', 1, 64) + "k"
+}
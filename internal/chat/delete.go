@@ -0,0 +1,248 @@
+package chat
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/charmbracelet/bubbles/key"
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+	"github.com/gaurav-gosain/gollama/internal/roles"
+	"github.com/gaurav-gosain/gollama/internal/utils"
+	"github.com/muesli/reflow/truncate"
+)
+
+type DeleteAction string
+
+// DeleteAction is an enum for the ways a highlighted message can be removed
+const (
+	DeleteMessage  DeleteAction = "DeleteMessage"
+	DeletePair     DeleteAction = "DeletePair"
+	TruncateAfter  DeleteAction = "TruncateAfter"
+	ClearChat      DeleteAction = "ClearChat"
+	DeleteCanceled DeleteAction = "DeleteCanceled"
+)
+
+// Sets (notification) the provided message and clears it after 3 seconds
+func (chat *Chat) notify(notification string) tea.Cmd {
+	chat.notification = notification
+	chat.notificationVisible = true
+
+	return clearNotificationAfter(time.Second * 3)
+}
+
+// Handles the key presses while the delete confirmation overlay is visible
+func (chat *Chat) handleDeleteKeys(msg tea.KeyMsg) tea.Cmd {
+	action := DeleteCanceled
+
+	if chat.confirmingClear {
+		switch {
+		case key.Matches(msg, Keys.Confirm):
+			action = ClearChat
+		case key.Matches(msg, Keys.Deny, Keys.Close):
+			action = DeleteCanceled
+		default:
+			return nil
+		}
+
+		chat.confirmingDelete = false
+		chat.confirmingClear = false
+
+		if action == DeleteCanceled {
+			return nil
+		}
+		return chat.clearHistory()
+	}
+
+	switch {
+	case key.Matches(msg, Keys.Close, Keys.DeleteMessage):
+		action = DeleteCanceled
+	case key.Matches(msg, Keys.DeleteOne):
+		action = DeleteMessage
+	case key.Matches(msg, Keys.DeletePair):
+		action = DeletePair
+	case key.Matches(msg, Keys.DeleteAfter):
+		action = TruncateAfter
+	case key.Matches(msg, Keys.Deny):
+		action = DeleteCanceled
+	default:
+		// ignore any other key, the overlay stays open
+		return nil
+	}
+
+	chat.confirmingDelete = false
+
+	if action == DeleteCanceled {
+		return nil
+	}
+
+	return chat.deleteHighlighted(action)
+}
+
+// Removes the highlighted message from the history according to the provided
+// action and saves the chat
+func (chat *Chat) deleteHighlighted(action DeleteAction) tea.Cmd {
+	if len(chat.ChatHistory) == 0 {
+		return nil
+	}
+
+	node := chat.ChatHistory[chat.highlightedChatIndex]
+
+	notification := ""
+
+	switch action {
+	case DeleteMessage:
+		node.Unlink()
+		notification = "Deleted highlighted message"
+	case DeletePair:
+		// a user message is paired with its reply, a reply with its prompt
+		user, reply := node, node.ActiveChild()
+		if node.Role == roles.ASSISTANT {
+			user, reply = node.parent, node
+		}
+
+		hasReply := reply != nil && reply.Role == roles.ASSISTANT
+		hasUser := user != nil && user != chat.history && user.Role == roles.USER
+
+		switch {
+		case hasUser && hasReply:
+			// the other replies of the user message (regenerations) are deleted
+			// with it, only the messages after the active reply are kept
+			user.Children = []*ChatNode{reply}
+			user.Selected = 0
+			reply.Unlink()
+			user.Unlink()
+		case hasReply:
+			reply.Unlink()
+		case hasUser:
+			user.Unlink()
+		}
+		notification = "Deleted highlighted message and its pair"
+	case TruncateAfter:
+		node.Truncate()
+		notification = "Deleted every message after the highlighted one"
+	}
+
+	// the message being edited might not exist anymore
+	chat.editing = nil
+	chat.streamErr = nil
+
+	chat.ChatHistory = chat.history.ActivePath()
+	chat.highlightedChatIndex = min(chat.highlightedChatIndex, len(chat.ChatHistory)-1)
+	chat.highlightedChatIndex = max(chat.highlightedChatIndex, 0)
+
+	renderCmd := chat.renderChatState()
+	chat.redrawViewport()
+
+	if err := chat.SaveHistory(); err != nil {
+		notification = err.Error()
+	}
+
+	return tea.Batch(renderCmd, chat.notify(notification))
+}
+
+// Deletes every message of the chat (including all of the branches) and saves
+// the chat
+func (chat *Chat) clearHistory() tea.Cmd {
+	chat.history.Truncate()
+	chat.ChatHistory = nil
+	chat.chatState = nil
+	chat.highlightedChatIndex = 0
+	chat.streamErr = nil
+	chat.editing = nil
+	chat.redrawViewport()
+
+	if err := chat.SaveHistory(); err != nil {
+		return chat.notify(err.Error())
+	}
+	return chat.notify("Cleared the chat")
+}
+
+// Renders the delete confirmation overlay on top of the provided content
+func (chat *Chat) deleteOverlayView(content string) string {
+	if len(chat.ChatHistory) == 0 {
+		return content
+	}
+
+	if chat.confirmingClear {
+		return chat.clearOverlayView(content)
+	}
+
+	node := chat.ChatHistory[chat.highlightedChatIndex]
+
+	width := max(30, chat.width/2)
+
+	preview := strings.TrimSpace(strings.Split(strings.TrimSpace(node.Message), "\n")[0])
+	preview = truncate.StringWithTail(preview, uint(width-8), "…")
+
+	pair := "the reply to it"
+	if node.Role == roles.ASSISTANT {
+		pair = "the message it replies to"
+	}
+
+	options := keyOptionsView(
+		keyOption{Keys.DeleteOne, "delete this message"},
+		keyOption{Keys.DeletePair, "delete it together with " + pair},
+		keyOption{Keys.DeleteAfter, "delete everything after it"},
+		keyOption{Keys.Close, "cancel"},
+	)
+
+	overlay := addToBorder(
+		layoutStyle.
+			Border(lipgloss.RoundedBorder(), false, true, true).
+			Width(width).
+			Padding(1, 2).
+			BorderForeground(dangerColor).
+			Render(
+				lipgloss.JoinVertical(
+					lipgloss.Left,
+					fmt.Sprintf("%s: %s", node.Role, lipgloss.NewStyle().Foreground(mutedColor).Render(preview)),
+					"",
+					options,
+				),
+			),
+		ErrorStyle.Render(" Delete "),
+		dangerColor,
+		"",
+	)
+
+	return utils.PlaceOverlay(
+		(chat.width-lipgloss.Width(overlay))/2,
+		(chat.height-lipgloss.Height(overlay))/2,
+		overlay,
+		content,
+	)
+}
+
+// Renders the confirmation of /clear on top of the provided content
+func (chat *Chat) clearOverlayView(content string) string {
+	overlay := addToBorder(
+		layoutStyle.
+			Border(lipgloss.RoundedBorder(), false, true, true).
+			Width(max(30, chat.width/2)).
+			Padding(1, 2).
+			BorderForeground(dangerColor).
+			Render(
+				lipgloss.JoinVertical(
+					lipgloss.Left,
+					"Delete every message of the chat, including all of the branches?",
+					"",
+					keyOptionsView(
+						keyOption{Keys.Confirm, "delete everything"},
+						keyOption{Keys.Deny, "cancel"},
+					),
+				),
+			),
+		ErrorStyle.Render(" Clear Chat "),
+		dangerColor,
+		"",
+	)
+
+	return utils.PlaceOverlay(
+		(chat.width-lipgloss.Width(overlay))/2,
+		(chat.height-lipgloss.Height(overlay))/2,
+		overlay,
+		content,
+	)
+}
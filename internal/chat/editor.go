@@ -0,0 +1,106 @@
+package chat
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// the editor used when neither $VISUAL nor $EDITOR are set
+const defaultEditor = "nano"
+
+// the height of the prompt (including its title), it grows with the number of
+// lines of the draft up to maxPromptHeight
+const (
+	minPromptHeight = 3
+	maxPromptHeight = 12
+)
+
+// EditorFinishedMsg is sent with the content of the prompt once the external
+// editor exits
+type EditorFinishedMsg struct {
+	Content string
+	Err     error
+}
+
+// Returns the command of the user's editor ($VISUAL, then $EDITOR) and its
+// arguments
+func editorCommand() (string, []string) {
+	for _, env := range []string{"VISUAL", "EDITOR"} {
+		if editor := strings.Fields(os.Getenv(env)); len(editor) > 0 {
+			return editor[0], editor[1:]
+		}
+	}
+
+	return defaultEditor, nil
+}
+
+// Opens the draft in the user's editor, the program is suspended until the
+// editor exits
+func (chat *Chat) openEditor() tea.Cmd {
+	file, err := os.CreateTemp("", "gollama-prompt-*.md")
+	if err != nil {
+		return chat.notify(fmt.Sprintf("Could not open the editor: %v", err))
+	}
+
+	if _, err := file.WriteString(chat.draft); err != nil {
+		file.Close()           //nolint:errcheck
+		os.Remove(file.Name()) //nolint:errcheck
+		return chat.notify(fmt.Sprintf("Could not open the editor: %v", err))
+	}
+	file.Close() //nolint:errcheck
+
+	editor, args := editorCommand()
+	cmd := exec.Command(editor, append(args, file.Name())...) //nolint:gosec
+
+	return tea.ExecProcess(cmd, func(err error) tea.Msg {
+		defer os.Remove(file.Name()) //nolint:errcheck
+
+		if err != nil {
+			return EditorFinishedMsg{Err: err}
+		}
+
+		content, err := os.ReadFile(file.Name())
+		return EditorFinishedMsg{Content: string(content), Err: err}
+	})
+}
+
+// Loads the prompt written in the external editor into the prompt
+func (chat *Chat) finishEditor(msg EditorFinishedMsg) tea.Cmd {
+	if msg.Err != nil {
+		return chat.notify(fmt.Sprintf("Could not read the prompt from the editor: %v", msg.Err))
+	}
+
+	// editors usually end the file with a newline
+	chat.setDraft(strings.TrimRight(msg.Content, "\n"))
+	cmd := chat.focusPrompt()
+	chat.layoutViewport()
+
+	return cmd
+}
+
+// Returns the height of the prompt for the current draft
+func (chat *Chat) promptHeight() int {
+	lines := strings.Count(chat.draft, "\n") + 1
+	// the title takes up a line
+	return min(max(lines+1, minPromptHeight), maxPromptHeight)
+}
+
+// Grows (or shrinks) the prompt to fit the lines of the draft
+func (chat *Chat) resizePrompt() {
+	if chat.promptField == nil {
+		return
+	}
+
+	height := chat.promptHeight()
+	if height == chat.promptFieldHeight {
+		return
+	}
+
+	chat.promptFieldHeight = height
+	chat.promptField.WithHeight(height)
+	chat.layoutViewport()
+}
@@ -0,0 +1,316 @@
+package chat
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+
+	"github.com/charmbracelet/bubbles/key"
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+	"github.com/gaurav-gosain/gollama/internal/client"
+	"github.com/gaurav-gosain/gollama/internal/roles"
+	"github.com/gaurav-gosain/gollama/internal/utils"
+)
+
+const (
+	// the time a command can run before it is killed
+	execTimeout = time.Minute
+	// the number of bytes of stdout/stderr sent to the model (the end of the
+	// output is kept)
+	maxExecOutput = 16 * 1024
+	// the number of lines of the command shown at once in the confirmation
+	// overlay, the longer commands scroll
+	execPreviewLines = 15
+)
+
+// the shells used to run the code blocks of each language
+var shellInterpreters = map[string]string{
+	"bash":  "bash",
+	"sh":    "sh",
+	"shell": "sh",
+	"zsh":   "zsh",
+}
+
+// ExecFinishedMsg is sent with the result of a code block once it was run
+type ExecFinishedMsg struct {
+	Block    CodeBlock
+	Stdout   string
+	Stderr   string
+	ExitCode int
+	Duration time.Duration
+	TimedOut bool
+	Canceled bool
+	Err      error // set if the command could not be started
+}
+
+// Returns true if the code block can be run
+func (block CodeBlock) runnable() bool {
+	_, ok := shellInterpreters[block.Language]
+	return ok
+}
+
+// Asks for the confirmation to run the code block of the highlighted message
+func (chat *Chat) requestExec(block CodeBlock) tea.Cmd {
+	switch {
+	case chat.ChatHistory[chat.highlightedChatIndex].Role != roles.ASSISTANT:
+		return chat.notify("Only the code blocks of replies can be run")
+	case !block.runnable():
+		return chat.notify("Only bash/sh/zsh code blocks can be run")
+	case chat.ChatSettings.IsAnonymous:
+		// the runs are logged with the chat, anonymous chats are not saved
+		return chat.notify("Code can't be run in anonymous chats, their runs can't be logged")
+	case !chat.ChatSettings.AllowExec:
+		return chat.notify("Running code is disabled for this chat, allow it in the chat settings (" + Keys.Settings.Help().Key + ")")
+	}
+
+	chat.pendingExec = &block
+	chat.approval = newApprovalPreview(strings.TrimRight(block.Code, "\n"))
+	return nil
+}
+
+// Handles the key presses while the run confirmation overlay is visible
+func (chat *Chat) handleExecKeys(msg tea.KeyMsg) tea.Cmd {
+	switch {
+	case key.Matches(msg, Keys.Confirm):
+		// the code can't be run before all of it was shown
+		if !chat.approval.seen {
+			return chat.notify("Scroll to the end of the code before running it")
+		}
+		block := *chat.pendingExec
+		chat.pendingExec = nil
+		chat.approval = nil
+		return chat.runExec(block)
+	case key.Matches(msg, Keys.Deny, Keys.Close):
+		chat.pendingExec = nil
+		chat.approval = nil
+	default:
+		chat.approval.update(msg)
+	}
+
+	return nil
+}
+
+// Runs the code block in a subprocess, the prompt is disabled until it exits
+func (chat *Chat) runExec(block CodeBlock) tea.Cmd {
+	ctx, cancel := context.WithTimeout(context.Background(), execTimeout)
+	chat.cancelExec = cancel
+	chat.executing = true
+
+	resetChatCmd := chat.resetPrompt(
+		mutedColor,
+		contrastColor,
+		"Disabled while the command is running...",
+		DisabledHighlightStyle,
+		false,
+	)
+
+	execCmd := func() tea.Msg {
+		defer cancel()
+
+		cmd := exec.CommandContext(ctx, shellInterpreters[block.Language], "-c", block.Code) //nolint:gosec
+		// the output pipes of processes started by the command could keep
+		// Wait from returning once it is killed
+		cmd.WaitDelay = time.Second
+
+		var stdout, stderr bytes.Buffer
+		cmd.Stdout = &stdout
+		cmd.Stderr = &stderr
+
+		start := time.Now()
+		err := cmd.Run()
+
+		// the command might not have started at all
+		exitCode := -1
+		if cmd.ProcessState != nil {
+			exitCode = cmd.ProcessState.ExitCode()
+		}
+
+		result := ExecFinishedMsg{
+			Block:    block,
+			Stdout:   stdout.String(),
+			Stderr:   stderr.String(),
+			ExitCode: exitCode,
+			Duration: time.Since(start),
+			TimedOut: errors.Is(ctx.Err(), context.DeadlineExceeded),
+			Canceled: errors.Is(ctx.Err(), context.Canceled),
+		}
+
+		var exitErr *exec.ExitError
+		if err != nil && !errors.As(err, &exitErr) && ctx.Err() == nil {
+			result.Err = err
+		}
+
+		return result
+	}
+
+	return tea.Batch(append(resetChatCmd, execCmd)...)
+}
+
+// Stops the running command
+func (chat *Chat) cancelExecution() {
+	if chat.cancelExec != nil {
+		chat.cancelExec()
+		chat.cancelExec = nil
+	}
+}
+
+// Logs the result of the command and sends it to the model as a (collapsed)
+// user message
+func (chat *Chat) finishExec(msg ExecFinishedMsg) tea.Cmd {
+	chat.executing = false
+	chat.cancelExec = nil
+
+	if msg.Err != nil {
+		return tea.Batch(
+			chat.focusPrompt(),
+			chat.notify(fmt.Sprintf("Could not run the command: %v", msg.Err)),
+		)
+	}
+
+	// the chat might have been made anonymous while the command ran
+	var logCmd tea.Cmd
+	if !chat.ChatSettings.IsAnonymous {
+		if err := client.GollamaInstance.LogExecution(client.Execution{
+			ChatID:   chat.ChatSettings.ID,
+			Command:  msg.Block.Code,
+			Stdout:   msg.Stdout,
+			Stderr:   msg.Stderr,
+			ExitCode: msg.ExitCode,
+			Duration: msg.Duration,
+		}); err != nil {
+			logCmd = chat.notify(err.Error())
+		}
+	}
+
+	if msg.Canceled {
+		return tea.Batch(
+			chat.focusPrompt(),
+			logCmd,
+			chat.notify("The command was stopped, its output was not sent"),
+		)
+	}
+
+	// the output is sent like a prompt (without the attachments of the tray),
+	// the model replies to it
+	chat.addMessage(ChatMessage{
+		Role:      roles.USER,
+		Message:   strings.TrimSpace(formatExecOutput(msg)),
+		Collapsed: true,
+	})
+	chat.toolRounds = 0
+	streamCmd := chat.streamResponse()
+
+	resetChatCmd := chat.resetPrompt(
+		mutedColor,
+		contrastColor,
+		"Disabled while response is being streamed...",
+		DisabledHighlightStyle,
+		false,
+	)
+
+	return tea.Batch(append(resetChatCmd, streamCmd, logCmd)...)
+}
+
+// Formats the result of the command as the message sent to the model
+func formatExecOutput(msg ExecFinishedMsg) string {
+	var b strings.Builder
+
+	status := fmt.Sprintf("exit code %d, %s", msg.ExitCode, msg.Duration.Round(time.Millisecond))
+	if msg.TimedOut {
+		status = fmt.Sprintf("killed after the %s timeout", execTimeout)
+	}
+
+	// the first line is the preview of the collapsed message
+	fmt.Fprintf(&b, "Ran the %s code block (%s):\n\n", msg.Block.Language, status)
+	fmt.Fprintf(&b, "```%s\n%s\n```\n", msg.Block.Language, strings.TrimRight(msg.Block.Code, "\n"))
+
+	outputs := []struct{ name, output string }{
+		{"stdout", msg.Stdout},
+		{"stderr", msg.Stderr},
+	}
+
+	for _, output := range outputs {
+		text := strings.TrimRight(output.output, "\n")
+		if text == "" {
+			fmt.Fprintf(&b, "\n%s: (empty)\n", output.name)
+			continue
+		}
+
+		if len(text) > maxExecOutput {
+			text = "(truncated, only the end of the output is included)\n" +
+				strings.ToValidUTF8(text[len(text)-maxExecOutput:], "")
+		}
+
+		fence := "```"
+		for strings.Contains(text, fence) {
+			fence += "`"
+		}
+
+		fmt.Fprintf(&b, "\n%s:\n%s\n%s\n%s\n", output.name, fence, text, fence)
+	}
+
+	return b.String()
+}
+
+// Renders the run confirmation overlay on top of the provided content
+func (chat *Chat) execOverlayView(content string) string {
+	block := chat.pendingExec
+	width := max(40, 6*chat.width/10)
+
+	// the padding of the overlay and the border of the preview
+	chat.approval.resize(width - 6)
+
+	run := "run"
+	if !chat.approval.seen {
+		run = "run (scroll to the end first)"
+	}
+
+	workingDirectory, _ := os.Getwd()
+
+	overlay := addToBorder(
+		layoutStyle.
+			Border(lipgloss.RoundedBorder(), false, true, true).
+			Width(width).
+			Padding(1, 2).
+			BorderForeground(dangerColor).
+			Render(
+				lipgloss.JoinVertical(
+					lipgloss.Left,
+					fmt.Sprintf(
+						"Run this %s code with %s in %s?",
+						block.Language,
+						shellInterpreters[block.Language],
+						workingDirectory,
+					),
+					"",
+					chat.approval.view(),
+					"",
+					lipgloss.NewStyle().Foreground(mutedColor).Render(fmt.Sprintf(
+						"The output is sent to the model, the command is killed after %s",
+						execTimeout,
+					)),
+					"",
+					keyOptionsView(
+						keyOption{Keys.Confirm, run},
+						keyOption{Keys.Deny, "cancel"},
+					),
+				),
+			),
+		ErrorStyle.Render(" Run Code "),
+		dangerColor,
+		"",
+	)
+
+	return utils.PlaceOverlay(
+		(chat.width-lipgloss.Width(overlay))/2,
+		(chat.height-lipgloss.Height(overlay))/2,
+		overlay,
+		content,
+	)
+}
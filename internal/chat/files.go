@@ -0,0 +1,135 @@
+package chat
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/charmbracelet/lipgloss"
+	"github.com/dustin/go-humanize"
+	"github.com/gaurav-gosain/gollama/internal/utils"
+	"github.com/muesli/reflow/truncate"
+)
+
+// the maximum number of bytes of a text file that are sent to the model,
+// longer files are truncated
+const maxFileAttachmentSize = 64 * 1024
+
+// the extensions of the files attached as images (multimodal models only)
+var imageExtensions = []string{".png", ".jpeg", ".jpg"}
+
+// A text file attached to a message, the content is read when the file is
+// attached and stored with the message
+type FileAttachment struct {
+	Path      string
+	Content   string
+	Size      int64 // the size of the file on disk
+	Truncated bool  // true if only the first maxFileAttachmentSize bytes were kept
+}
+
+// Returns the name of the attached file
+func (file FileAttachment) Name() string {
+	return filepath.Base(file.Path)
+}
+
+// Returns true if the file should be attached as an image
+func isImage(path string) bool {
+	return slices.Contains(imageExtensions, strings.ToLower(filepath.Ext(path)))
+}
+
+// Reads a text file to attach it to a message, binary files are rejected and
+// large files are truncated to maxFileAttachmentSize bytes
+func readFileAttachment(path string) (FileAttachment, error) {
+	expandedPath, err := utils.ExpandPath(path)
+	if err != nil {
+		return FileAttachment{}, err
+	}
+
+	file, err := os.Open(expandedPath)
+	if err != nil {
+		return FileAttachment{}, err
+	}
+	defer file.Close() //nolint:errcheck
+
+	info, err := file.Stat()
+	if err != nil {
+		return FileAttachment{}, err
+	}
+
+	if info.IsDir() {
+		return FileAttachment{}, fmt.Errorf("%s is a directory", filepath.Base(path))
+	}
+
+	data, err := io.ReadAll(io.LimitReader(file, maxFileAttachmentSize))
+	if err != nil {
+		return FileAttachment{}, err
+	}
+
+	if bytes.IndexByte(data, 0) >= 0 {
+		return FileAttachment{}, fmt.Errorf("%s is not a text file", filepath.Base(path))
+	}
+
+	return FileAttachment{
+		Path: path,
+		// the limit might split a multi-byte character
+		Content:   strings.ToValidUTF8(string(data), ""),
+		Size:      info.Size(),
+		Truncated: info.Size() > maxFileAttachmentSize,
+	}, nil
+}
+
+// Returns the content of the message sent to the model, the attached files
+// are inlined after the message with their names as headers
+func (msg ChatMessage) requestContent() string {
+	if len(msg.Files) == 0 {
+		return msg.Message
+	}
+
+	var b strings.Builder
+	b.WriteString(msg.Message)
+
+	for _, file := range msg.Files {
+		// the fence has to be longer than any fence in the file
+		fence := "```"
+		for strings.Contains(file.Content, fence) {
+			fence += "`"
+		}
+
+		fmt.Fprintf(&b, "\n\nFile: %s\n", file.Name())
+		fmt.Fprintf(&b, "%s%s\n%s\n%s", fence, strings.TrimPrefix(filepath.Ext(file.Path), "."), strings.TrimRight(file.Content, "\n"), fence)
+
+		if file.Truncated {
+			fmt.Fprintf(
+				&b,
+				"\n(truncated, only the first %s of %s are included)",
+				humanize.IBytes(maxFileAttachmentSize),
+				humanize.IBytes(uint64(file.Size)),
+			)
+		}
+	}
+
+	return b.String()
+}
+
+// Renders the files attached to a message as collapsed chips (one per line)
+func fileChipsView(files []FileAttachment, style lipgloss.Style) string {
+	chips := []string{}
+	for _, file := range files {
+		label := fmt.Sprintf(
+			"󰈙 %s %s",
+			truncate.StringWithTail(file.Name(), 24, "…"),
+			humanize.IBytes(uint64(file.Size)),
+		)
+		if file.Truncated {
+			label += " (truncated)"
+		}
+
+		chips = append(chips, style.Render(label))
+	}
+
+	return lipgloss.JoinVertical(lipgloss.Left, chips...)
+}
@@ -0,0 +1,215 @@
+package chat
+
+import (
+	"bytes"
+	"encoding/gob"
+	"fmt"
+	"io"
+	"path/filepath"
+
+	"github.com/adrg/xdg"
+)
+
+// ChatNode is a message in the chat history tree. The children of a node are
+// the alternative continuations of the conversation after it (e.g. the
+// regenerated responses to a user message), only the selected child is part
+// of the active conversation
+type ChatNode struct {
+	ChatMessage
+	parent   *ChatNode
+	Children []*ChatNode
+	Selected int
+
+	// the estimated tokens of the message in a request, estimated when the
+	// message had tokensLength bytes (a streamed message keeps growing)
+	tokens       int
+	tokensLength int
+}
+
+// Adds a new child to the node and makes it the selected one
+func (node *ChatNode) AddChild(msg ChatMessage) *ChatNode {
+	child := &ChatNode{
+		ChatMessage: msg,
+		parent:      node,
+	}
+
+	node.Children = append(node.Children, child)
+	node.Selected = len(node.Children) - 1
+
+	return child
+}
+
+// Returns the selected child of the node (nil if it has none)
+func (node *ChatNode) ActiveChild() *ChatNode {
+	if len(node.Children) == 0 {
+		return nil
+	}
+
+	node.Selected = min(max(node.Selected, 0), len(node.Children)-1)
+	return node.Children[node.Selected]
+}
+
+// Removes the provided child (and everything below it) from the node
+func (node *ChatNode) RemoveChild(child *ChatNode) {
+	for i := range node.Children {
+		if node.Children[i] != child {
+			continue
+		}
+
+		node.Children = append(node.Children[:i], node.Children[i+1:]...)
+		if node.Selected >= i {
+			node.Selected = max(0, node.Selected-1)
+		}
+
+		return
+	}
+}
+
+// Removes the node from the tree, its children take its place among its
+// siblings so the rest of the conversation is kept
+func (node *ChatNode) Unlink() {
+	parent := node.parent
+	if parent == nil {
+		return
+	}
+
+	position, _ := node.Position()
+
+	children := append([]*ChatNode{}, parent.Children[:position]...)
+	children = append(children, node.Children...)
+	children = append(children, parent.Children[position+1:]...)
+
+	for _, child := range node.Children {
+		child.parent = parent
+	}
+
+	switch {
+	case parent.Selected == position && len(node.Children) > 0:
+		parent.Selected = position + node.Selected
+	case parent.Selected > position:
+		parent.Selected += len(node.Children) - 1
+	}
+
+	parent.Children = children
+	parent.Selected = min(max(parent.Selected, 0), max(len(children)-1, 0))
+	node.parent = nil
+}
+
+// Removes every message after the node (including all of their branches)
+func (node *ChatNode) Truncate() {
+	node.Children = nil
+	node.Selected = 0
+}
+
+// Returns the index of the node among its siblings and the number of siblings
+// (including the node itself)
+func (node *ChatNode) Position() (int, int) {
+	if node.parent == nil {
+		return 0, 1
+	}
+
+	for i := range node.parent.Children {
+		if node.parent.Children[i] == node {
+			return i, len(node.parent.Children)
+		}
+	}
+
+	return 0, 1
+}
+
+// Returns the messages of the active conversation below the node, following
+// the selected child at every level
+func (node *ChatNode) ActivePath() []*ChatNode {
+	path := []*ChatNode{}
+
+	for current := node.ActiveChild(); current != nil; current = current.ActiveChild() {
+		path = append(path, current)
+	}
+
+	return path
+}
+
+// Calls fn for every message below the node
+func (node *ChatNode) Walk(fn func(*ChatNode)) {
+	for _, child := range node.Children {
+		fn(child)
+		child.Walk(fn)
+	}
+}
+
+// Restores the parent pointers of the tree (they are not encoded)
+func (node *ChatNode) linkParents() {
+	for _, child := range node.Children {
+		child.parent = node
+		child.linkParents()
+	}
+}
+
+// The on-disk format of the chat history, files written before the history
+// became a tree hold a plain []ChatMessage instead
+type historyFile struct {
+	Version int
+	Root    *ChatNode
+}
+
+const historyFileVersion = 1
+
+// Returns the path of the file the history of the chat with the provided ID
+// is stored in
+func HistoryPath(id string) string {
+	return filepath.Join(
+		xdg.DataHome,
+		"gollama",
+		"chats",
+		id+".gob",
+	)
+}
+
+// Encodes the provided history tree to gob format and writes it to the writer
+func EncodeGob(w io.Writer, root *ChatNode) error {
+	file := historyFile{
+		Version: historyFileVersion,
+		Root:    root,
+	}
+
+	if err := gob.NewEncoder(w).Encode(&file); err != nil {
+		return fmt.Errorf("encode: %w", err)
+	}
+	return nil
+}
+
+// Decodes the gob-encoded history from the reader and stores it in the
+// provided root node, legacy (linear) histories are converted to a tree
+func DecodeGob(r io.Reader, root *ChatNode) error {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return fmt.Errorf("decode: %w", err)
+	}
+
+	// a freshly created chat has an empty history file
+	if len(data) == 0 {
+		return nil
+	}
+
+	var file historyFile
+	if err := gob.NewDecoder(bytes.NewReader(data)).Decode(&file); err == nil {
+		if file.Root != nil {
+			*root = *file.Root
+		}
+		root.parent = nil
+		root.linkParents()
+		return nil
+	}
+
+	var messages []ChatMessage
+	if err := gob.NewDecoder(bytes.NewReader(data)).Decode(&messages); err != nil {
+		return fmt.Errorf("decode: %w", err)
+	}
+
+	node := root
+	for _, msg := range messages {
+		node = node.AddChild(msg)
+	}
+
+	return nil
+}
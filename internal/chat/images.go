@@ -0,0 +1,147 @@
+package chat
+
+import (
+	"fmt"
+	"os"
+	"sync"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+	"github.com/gaurav-gosain/gollama/internal/utils"
+)
+
+const (
+	// the number of rendered images kept in memory, the cache starts over
+	// once it is full
+	maxCachedImages = 64
+	// the number of images rendered at the same time
+	maxConcurrentImages = 2
+)
+
+// An image rendered at a height, the modification time makes sure an image
+// that changed on disk is rendered again
+type imageKey struct {
+	path    string
+	modTime int64
+	height  int
+}
+
+// ImageRenderedMsg is sent once an image was rendered in the background
+type ImageRenderedMsg struct {
+	key imageKey
+}
+
+var (
+	// the rendered images, shared by the chat and the background renders
+	imageCache   = map[imageKey]string{}
+	imageCacheMu sync.Mutex
+	// limits the number of images rendered at the same time
+	imageSlots = make(chan struct{}, maxConcurrentImages)
+)
+
+// Returns the cache key of the image at the provided height
+func newImageKey(path string, height int) imageKey {
+	key := imageKey{path: path, height: height}
+
+	if expandedPath, err := utils.ExpandPath(path); err == nil {
+		key.path = expandedPath
+	}
+	if info, err := os.Stat(key.path); err == nil {
+		key.modTime = info.ModTime().UnixNano()
+	}
+
+	return key
+}
+
+// Returns the rendered image if it is cached
+func cachedImage(key imageKey) (string, bool) {
+	imageCacheMu.Lock()
+	defer imageCacheMu.Unlock()
+
+	image, ok := imageCache[key]
+	return image, ok
+}
+
+// Stores the rendered image in the cache
+func cacheImage(key imageKey, image string) {
+	imageCacheMu.Lock()
+	defer imageCacheMu.Unlock()
+
+	if len(imageCache) >= maxCachedImages {
+		imageCache = map[imageKey]string{}
+	}
+	imageCache[key] = image
+}
+
+// Returns the rendered image, or a placeholder of the same height while it is
+// rendered in the background
+func imageView(key imageKey, muted lipgloss.Color) string {
+	if image, ok := cachedImage(key); ok {
+		return image
+	}
+
+	// the rendered image is two lines shorter than the requested height
+	height := max(1, key.height-2)
+
+	return lipgloss.NewStyle().
+		Width(2 * height).
+		Height(height).
+		Foreground(muted).
+		Italic(true).
+		Render("loading image…")
+}
+
+// Queues the image to be rendered once the update is done, it is only
+// requested once
+func (chat *Chat) requestImage(key imageKey) {
+	if chat.requestedImages == nil {
+		chat.requestedImages = map[imageKey]bool{}
+	}
+	if chat.requestedImages[key] {
+		return
+	}
+
+	chat.requestedImages[key] = true
+	chat.imageQueue = append(chat.imageQueue, key)
+}
+
+// Returns the commands rendering the queued images in the background
+func (chat *Chat) renderQueuedImages() tea.Cmd {
+	cmds := []tea.Cmd{}
+	for _, key := range chat.imageQueue {
+		cmds = append(cmds, renderImageCmd(key))
+	}
+	chat.imageQueue = nil
+
+	return tea.Batch(cmds...)
+}
+
+// Renders the image in the background, at most maxConcurrentImages are
+// rendered at the same time
+func renderImageCmd(key imageKey) tea.Cmd {
+	return func() tea.Msg {
+		imageSlots <- struct{}{}
+		defer func() { <-imageSlots }()
+
+		cacheImage(key, renderImage(key.path, key.height))
+
+		return ImageRenderedMsg{key: key}
+	}
+}
+
+// Re-renders the messages with images once an image is rendered
+func (chat *Chat) finishImage(msg ImageRenderedMsg) {
+	delete(chat.requestedImages, msg.key)
+
+	atBottom := chat.viewport.AtBottom()
+	for i, node := range chat.ChatHistory {
+		if len(node.Images) > 0 && i < len(chat.chatState) && chat.chatState[i] != "" {
+			chat.chatState[i] = chat.getMessageBubble(node, false, fmt.Sprintf("%d", i))
+		}
+	}
+
+	chat.redrawViewport()
+	if atBottom {
+		chat.viewport.GotoBottom()
+	}
+}
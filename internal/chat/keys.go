@@ -1,30 +1,89 @@
 package chat
 
-import "github.com/charmbracelet/bubbles/key"
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+	"unicode/utf8"
 
+	"github.com/charmbracelet/bubbles/key"
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/huh"
+	"github.com/charmbracelet/lipgloss"
+	"github.com/gaurav-gosain/gollama/internal/config"
+)
+
+// The keybindings of the chat, the keys can be overridden in the "keys.chat"
+// section of the config file (see ApplyKeys)
 type KeyMap struct {
-	Up                       key.Binding // ctrl+up
-	Down                     key.Binding // ctrl+down
-	HalfPageUp               key.Binding // ctrl+u
-	HalfPageDown             key.Binding // ctrl+d
-	HighlightPreviousMessage key.Binding // ctrl+p
-	HighlightNextMessage     key.Binding // ctrl+n
-	CopyHighlightedMessage   key.Binding // alt+c
-	CopyLastResponse         key.Binding // ctrl+shift+c
-	ToggleImagePicker        key.Binding // ctrl+o
-	RemoveAttachment         key.Binding // ctrl+x
-	ToggleHelp               key.Binding // ctrl+h
-	Quit                     key.Binding // ctrl+c
-	FullHelpKeys             [][]key.Binding
+	Up                       key.Binding
+	Down                     key.Binding
+	HalfPageUp               key.Binding
+	HalfPageDown             key.Binding
+	HighlightPreviousMessage key.Binding
+	HighlightNextMessage     key.Binding
+	CopyHighlightedMessage   key.Binding
+	CopyLastResponse         key.Binding
+	ToggleImagePicker        key.Binding
+	RemoveAttachment         key.Binding
+	ToggleHelp               key.Binding
+	StopResponse             key.Binding
+	Retry                    key.Binding
+	Regenerate               key.Binding
+	EditMessage              key.Binding
+	DeleteMessage            key.Binding
+	Search                   key.Binding
+	SwitchModel              key.Binding
+	Settings                 key.Binding
+	PreviousAlternative      key.Binding
+	NextAlternative          key.Binding
+	Compact                  key.Binding
+	NewLine                  key.Binding
+	OpenEditor               key.Binding
+	CodeBlocks               key.Binding
+	ToggleCollapsed          key.Binding
+	CompleteCommand          key.Binding
+	Quit                     key.Binding
+	// closes the overlays (search, attachments, code blocks...), it takes
+	// precedence over Quit while an overlay is open
+	Close key.Binding
+	// leaves the prompt for the normal mode if the vim mode is enabled, it
+	// takes precedence over Quit
+	NormalMode key.Binding
+
+	// the keys of the overlays, they are only matched while their overlay is
+	// open (the prompt isn't focused so single characters can be used)
+	Confirm            key.Binding
+	Deny               key.Binding
+	Submit             key.Binding
+	DeleteOne          key.Binding
+	DeletePair         key.Binding
+	DeleteAfter        key.Binding
+	NextMatch          key.Binding
+	PreviousMatch      key.Binding
+	NewSearch          key.Binding
+	PreviousItem       key.Binding
+	NextItem           key.Binding
+	SelectCodeBlock    key.Binding // the nth key selects the nth code block
+	CopyCodeBlock      key.Binding
+	SaveCodeBlock      key.Binding
+	RunCodeBlock       key.Binding
+	PreviousAttachment key.Binding
+	NextAttachment     key.Binding
+	RemoveSelected     key.Binding
+
+	FullHelpKeys [][]key.Binding
 }
 
 var Keys = KeyMap{
 	Up: key.NewBinding(
-		key.WithKeys("ctrl+up", "k"),
+		key.WithKeys("ctrl+up"),
 		key.WithHelp("ctrl+↑", "Move view up"),
 	),
 	Down: key.NewBinding(
-		key.WithKeys("ctrl+down", "j"),
+		key.WithKeys("ctrl+down"),
 		key.WithHelp("ctrl+↓", "Move view down"),
 	),
 	HalfPageUp: key.NewBinding(
@@ -53,20 +112,375 @@ var Keys = KeyMap{
 	),
 	ToggleImagePicker: key.NewBinding(
 		key.WithKeys("ctrl+o"),
-		key.WithHelp("ctrl+o", "Toggle image picker"),
+		key.WithHelp("ctrl+o", "Toggle file picker"),
 	),
 	RemoveAttachment: key.NewBinding(
 		key.WithKeys("ctrl+x"),
-		key.WithHelp("ctrl+x", "Remove attachment"),
+		key.WithHelp("ctrl+x", "Manage attachments (x to remove)"),
 	),
 	ToggleHelp: key.NewBinding(
 		key.WithKeys("ctrl+h"),
 		key.WithHelp("ctrl+h", "Toggle help"),
 	),
+	StopResponse: key.NewBinding(
+		key.WithKeys("ctrl+s"),
+		key.WithHelp("ctrl+s", "Stop response"),
+	),
+	Retry: key.NewBinding(
+		key.WithKeys("ctrl+r"),
+		key.WithHelp("ctrl+r", "Retry failed request"),
+	),
+	Regenerate: key.NewBinding(
+		key.WithKeys("alt+r"),
+		key.WithHelp("alt+r", "Regenerate last response"),
+	),
+	EditMessage: key.NewBinding(
+		key.WithKeys("alt+e"),
+		key.WithHelp("alt+e", "Edit highlighted message (new branch)"),
+	),
+	DeleteMessage: key.NewBinding(
+		key.WithKeys("alt+d"),
+		key.WithHelp("alt+d", "Delete/truncate highlighted message"),
+	),
+	Search: key.NewBinding(
+		key.WithKeys("ctrl+f"),
+		key.WithHelp("ctrl+f", "Search messages (n/N next/previous match)"),
+	),
+	SwitchModel: key.NewBinding(
+		key.WithKeys("alt+m"),
+		key.WithHelp("alt+m", "Switch model (tab to cycle models)"),
+	),
+	Settings: key.NewBinding(
+		key.WithKeys("alt+s"),
+		key.WithHelp("alt+s", "Chat settings"),
+	),
+	PreviousAlternative: key.NewBinding(
+		key.WithKeys("alt+left"),
+		key.WithHelp("alt+←", "Previous alternative/branch"),
+	),
+	NextAlternative: key.NewBinding(
+		key.WithKeys("alt+right"),
+		key.WithHelp("alt+→", "Next alternative/branch"),
+	),
+	Compact: key.NewBinding(
+		key.WithKeys("alt+c"),
+		key.WithHelp("alt+c", "Compact older messages into a summary"),
+	),
+	NewLine: key.NewBinding(
+		key.WithKeys("alt+enter", "ctrl+j"),
+		key.WithHelp("alt+enter/ctrl+j", "Insert a new line"),
+	),
+	OpenEditor: key.NewBinding(
+		key.WithKeys("ctrl+e"),
+		key.WithHelp("ctrl+e", "Write the prompt in $VISUAL/$EDITOR"),
+	),
+	CodeBlocks: key.NewBinding(
+		key.WithKeys("alt+b"),
+		key.WithHelp("alt+b", "Copy/save/run a code block of the highlighted message"),
+	),
+	ToggleCollapsed: key.NewBinding(
+		key.WithKeys("alt+z"),
+		key.WithHelp("alt+z", "Fold/unfold highlighted message"),
+	),
+	CompleteCommand: key.NewBinding(
+		key.WithKeys("tab"),
+		key.WithHelp("tab", "Complete the slash command"),
+	),
 	Quit: key.NewBinding(
-		key.WithKeys("ctrl+c"),
-		key.WithHelp("ctrl+c", "Exit chat"),
+		key.WithKeys("ctrl+c", "esc"),
+		key.WithHelp("ctrl+c/esc", "Exit chat"),
+	),
+	Close: key.NewBinding(
+		key.WithKeys("esc"),
+		key.WithHelp("esc", "Close the overlay"),
+	),
+	NormalMode: key.NewBinding(
+		key.WithKeys("esc"),
+		key.WithHelp("esc", "Normal mode"),
+	),
+	Confirm: key.NewBinding(
+		key.WithKeys("y", "enter"),
+		key.WithHelp("y", "Confirm"),
+	),
+	Deny: key.NewBinding(
+		key.WithKeys("n", "q"),
+		key.WithHelp("n", "Cancel"),
+	),
+	Submit: key.NewBinding(
+		key.WithKeys("enter"),
+		key.WithHelp("enter", "Submit"),
+	),
+	DeleteOne: key.NewBinding(
+		key.WithKeys("d"),
+		key.WithHelp("d", "Delete the message"),
+	),
+	DeletePair: key.NewBinding(
+		key.WithKeys("p"),
+		key.WithHelp("p", "Delete the message and its pair"),
+	),
+	DeleteAfter: key.NewBinding(
+		key.WithKeys("t"),
+		key.WithHelp("t", "Delete every message after it"),
 	),
+	NextMatch: key.NewBinding(
+		key.WithKeys("n"),
+		key.WithHelp("n", "Next match"),
+	),
+	PreviousMatch: key.NewBinding(
+		key.WithKeys("N"),
+		key.WithHelp("N", "Previous match"),
+	),
+	NewSearch: key.NewBinding(
+		key.WithKeys("/"),
+		key.WithHelp("/", "New search"),
+	),
+	PreviousItem: key.NewBinding(
+		key.WithKeys("up", "k", "shift+tab"),
+		key.WithHelp("↑", "Previous item"),
+	),
+	NextItem: key.NewBinding(
+		key.WithKeys("down", "j", "tab"),
+		key.WithHelp("↓", "Next item"),
+	),
+	SelectCodeBlock: key.NewBinding(
+		key.WithKeys("1", "2", "3", "4", "5", "6", "7", "8", "9"),
+		key.WithHelp("1-9", "Select a code block"),
+	),
+	CopyCodeBlock: key.NewBinding(
+		key.WithKeys("y", "enter"),
+		key.WithHelp("y", "Copy"),
+	),
+	SaveCodeBlock: key.NewBinding(
+		key.WithKeys("s", "w"),
+		key.WithHelp("s", "Save to file"),
+	),
+	RunCodeBlock: key.NewBinding(
+		key.WithKeys("r"),
+		key.WithHelp("r", "Run"),
+	),
+	PreviousAttachment: key.NewBinding(
+		key.WithKeys("left", "h", "shift+tab"),
+		key.WithHelp("←", "Previous attachment"),
+	),
+	NextAttachment: key.NewBinding(
+		key.WithKeys("right", "l", "tab"),
+		key.WithHelp("→", "Next attachment"),
+	),
+	RemoveSelected: key.NewBinding(
+		key.WithKeys("x", "d", "delete", "backspace"),
+		key.WithHelp("x", "Remove"),
+	),
+}
+
+// Returns the bindings by the names used in the config file
+func (k *KeyMap) bindings() map[string]*key.Binding {
+	return map[string]*key.Binding{
+		"up":                   &k.Up,
+		"down":                 &k.Down,
+		"half_page_up":         &k.HalfPageUp,
+		"half_page_down":       &k.HalfPageDown,
+		"previous_message":     &k.HighlightPreviousMessage,
+		"next_message":         &k.HighlightNextMessage,
+		"copy_message":         &k.CopyHighlightedMessage,
+		"copy_last_response":   &k.CopyLastResponse,
+		"file_picker":          &k.ToggleImagePicker,
+		"attachments":          &k.RemoveAttachment,
+		"help":                 &k.ToggleHelp,
+		"stop":                 &k.StopResponse,
+		"retry":                &k.Retry,
+		"regenerate":           &k.Regenerate,
+		"edit":                 &k.EditMessage,
+		"delete":               &k.DeleteMessage,
+		"search":               &k.Search,
+		"switch_model":         &k.SwitchModel,
+		"settings":             &k.Settings,
+		"previous_alternative": &k.PreviousAlternative,
+		"next_alternative":     &k.NextAlternative,
+		"compact":              &k.Compact,
+		"new_line":             &k.NewLine,
+		"editor":               &k.OpenEditor,
+		"code_blocks":          &k.CodeBlocks,
+		"fold":                 &k.ToggleCollapsed,
+		"complete":             &k.CompleteCommand,
+		"quit":                 &k.Quit,
+		"close":                &k.Close,
+		"normal_mode":          &k.NormalMode,
+	}
+}
+
+// Returns the bindings of the overlays by the names used in the config file
+func (k *KeyMap) overlayBindings() map[string]*key.Binding {
+	return map[string]*key.Binding{
+		"confirm":             &k.Confirm,
+		"deny":                &k.Deny,
+		"submit":              &k.Submit,
+		"delete_one":          &k.DeleteOne,
+		"delete_pair":         &k.DeletePair,
+		"delete_after":        &k.DeleteAfter,
+		"next_match":          &k.NextMatch,
+		"previous_match":      &k.PreviousMatch,
+		"new_search":          &k.NewSearch,
+		"previous_item":       &k.PreviousItem,
+		"next_item":           &k.NextItem,
+		"select_code_block":   &k.SelectCodeBlock,
+		"copy_code_block":     &k.CopyCodeBlock,
+		"save_code_block":     &k.SaveCodeBlock,
+		"run_code_block":      &k.RunCodeBlock,
+		"previous_attachment": &k.PreviousAttachment,
+		"next_attachment":     &k.NextAttachment,
+		"remove_selected":     &k.RemoveSelected,
+	}
+}
+
+// Returns the bindings matched by each overlay (in the order they are
+// checked), a key must only be bound to one of them
+func (k *KeyMap) overlays() map[string][]*key.Binding {
+	approval := []*key.Binding{&k.Confirm, &k.Deny, &k.Close, &k.PreviousItem, &k.NextItem, &k.HalfPageUp, &k.HalfPageDown}
+
+	return map[string][]*key.Binding{
+		"delete":          {&k.Close, &k.DeleteMessage, &k.DeleteOne, &k.DeletePair, &k.DeleteAfter, &k.Deny},
+		"clear":           {&k.Confirm, &k.Deny, &k.Close},
+		"search":          {&k.Close, &k.Submit},
+		"search results":  {&k.NextMatch, &k.PreviousMatch, &k.NewSearch, &k.Search, &k.Submit, &k.Close},
+		"code blocks":     {&k.Close, &k.CodeBlocks, &k.PreviousItem, &k.NextItem, &k.SelectCodeBlock, &k.CopyCodeBlock, &k.RunCodeBlock, &k.SaveCodeBlock, &k.Deny},
+		"save code block": {&k.Close, &k.Submit},
+		"overwrite":       {&k.Confirm, &k.Deny, &k.Close},
+		"attachments":     {&k.Close, &k.RemoveAttachment, &k.PreviousAttachment, &k.NextAttachment, &k.RemoveSelected, &k.Submit},
+		"run code":        approval,
+		"tool approval":   append(slices.Clone(approval), &k.StopResponse),
+	}
+}
+
+// ApplyKeys overrides the keys of the chat with the ones of the config file,
+// the keys are left unchanged if an override is unknown or conflicts with
+// another binding
+func ApplyKeys(overrides map[string][]string) error {
+	err := applyKeys(overrides)
+
+	// the keys entering the normal mode no longer exit the chat
+	if config.Current.VimMode {
+		quit := slices.DeleteFunc(slices.Clone(Keys.Quit.Keys()), func(k string) bool {
+			return slices.Contains(Keys.NormalMode.Keys(), k)
+		})
+		Keys.Quit.SetKeys(quit...)
+		Keys.Quit.SetHelp(config.HelpKey(quit), Keys.Quit.Help().Desc)
+		Keys.Quit.SetEnabled(len(quit) > 0)
+	}
+
+	return err
+}
+
+// A key of an overlay and what it does
+type keyOption struct {
+	binding     key.Binding
+	description string
+}
+
+// Renders the options of an overlay one per line, the descriptions are
+// aligned after the keys
+func keyOptionsView(options ...keyOption) string {
+	width := 0
+	for _, option := range options {
+		width = max(width, lipgloss.Width(option.binding.Help().Key))
+	}
+
+	lines := make([]string, len(options))
+	for i, option := range options {
+		k := option.binding.Help().Key
+		lines[i] = HighlightForegroundStyle.Render(k) + strings.Repeat(" ", width-lipgloss.Width(k)+2) + option.description
+	}
+
+	return strings.Join(lines, "\n")
+}
+
+// Returns the hint of the bindings, e.g. "↑/↓ select"
+func keyHint(description string, bindings ...key.Binding) string {
+	keys := make([]string, len(bindings))
+	for i, binding := range bindings {
+		keys[i] = binding.Help().Key
+	}
+
+	return strings.Join(keys, "/") + " " + description
+}
+
+// Renders the hints in a help line
+func keyHintsView(hints ...string) string {
+	return helpStyle(strings.Join(hints, " • "))
+}
+
+func applyKeys(overrides map[string][]string) error {
+	keys := Keys
+	bindings := keys.bindings()
+
+	all := keys.overlayBindings()
+	maps.Copy(all, bindings)
+	if err := config.ApplyKeys("chat", all, overrides); err != nil {
+		return err
+	}
+
+	var errs []error
+	for _, name := range slices.Sorted(maps.Keys(bindings)) {
+		for _, k := range bindings[name].Keys() {
+			// the characters are typed in the prompt
+			if utf8.RuneCountInString(k) == 1 {
+				errs = append(errs, fmt.Errorf("chat keybinding %s: %q can't be used, it is typed in the prompt", name, k))
+			}
+		}
+	}
+
+	// the overlays are closed (and the normal mode entered) before the other
+	// bindings are checked
+	delete(bindings, "close")
+	delete(bindings, "normal_mode")
+	errs = append(errs, config.KeyConflicts("chat", bindings))
+
+	// the keys of an overlay only conflict with the other keys it matches
+	names := map[*key.Binding]string{}
+	for name, binding := range all {
+		names[binding] = name
+	}
+	overlays := keys.overlays()
+	for _, overlay := range slices.Sorted(maps.Keys(overlays)) {
+		matched := map[string]*key.Binding{}
+		for _, binding := range overlays[overlay] {
+			matched[names[binding]] = binding
+		}
+		errs = append(errs, config.KeyConflicts(overlay+" overlay", matched))
+	}
+
+	if err := errors.Join(errs...); err != nil {
+		return err
+	}
+
+	// the help of the chat bindings mentions the keys of their overlay
+	keys.RemoveAttachment.SetHelp(
+		keys.RemoveAttachment.Help().Key,
+		fmt.Sprintf("Manage attachments (%s to remove)", keys.RemoveSelected.Help().Key),
+	)
+	keys.Search.SetHelp(
+		keys.Search.Help().Key,
+		fmt.Sprintf("Search messages (%s/%s next/previous match)", keys.NextMatch.Help().Key, keys.PreviousMatch.Help().Key),
+	)
+
+	Keys = keys
+	return nil
+}
+
+// Returns true if the key exits the chat while an overlay is open, the keys
+// that close the overlays are left to them
+func isForceQuit(msg tea.KeyMsg) bool {
+	return key.Matches(msg, Keys.Quit) && !key.Matches(msg, Keys.Close)
+}
+
+// Returns the keymap of the prompt, the new lines follow the chat's keys and
+// the chat handles the editor and quitting itself
+func promptKeyMap() *huh.KeyMap {
+	keymap := huh.NewDefaultKeyMap()
+	keymap.Text.NewLine = Keys.NewLine
+	keymap.Text.Editor.SetEnabled(false)
+	keymap.Quit.SetEnabled(false)
+
+	return keymap
 }
 
 // ShortHelp returns keybindings to be shown in the mini help view. It's part
@@ -88,33 +502,29 @@ func (k *KeyMap) DefaultFullHelpKeys() [][]key.Binding {
 			k.HalfPageDown,
 			k.CopyLastResponse,
 			k.ToggleHelp,
+			k.StopResponse,
+			k.Regenerate,
+			k.EditMessage,
+			k.DeleteMessage,
+			k.Search,
+			k.SwitchModel,
+			k.Settings,
+			k.NewLine,
+			k.OpenEditor,
+			k.CompleteCommand,
 		},
 		{
 			k.HighlightPreviousMessage,
 			k.HighlightNextMessage,
 			k.CopyHighlightedMessage,
+			k.CodeBlocks,
+			k.ToggleCollapsed,
 			k.ToggleImagePicker,
 			k.RemoveAttachment,
-			k.Quit,
-		},
-	}
-}
-
-func (k *KeyMap) DefaultFullHelpKeysNonMultiModal() [][]key.Binding {
-	return [][]key.Binding{
-		{
-			k.Up,
-			k.Down,
-			k.HalfPageUp,
-			k.HalfPageDown,
-			k.CopyLastResponse,
-			k.ToggleHelp,
-		},
-		{
-			k.HighlightPreviousMessage,
-			k.HighlightNextMessage,
-			k.CopyHighlightedMessage,
-			k.RemoveAttachment,
+			k.Retry,
+			k.PreviousAlternative,
+			k.NextAlternative,
+			k.Compact,
 			k.Quit,
 		},
 	}
@@ -0,0 +1,296 @@
+package chat
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+	"github.com/gaurav-gosain/gollama/internal/client"
+	"github.com/gaurav-gosain/gollama/internal/config"
+	"github.com/gaurav-gosain/gollama/internal/mcp"
+	"github.com/muesli/reflow/truncate"
+)
+
+// the time a server has to list its tools once it is initialized
+const mcpListTimeout = 30 * time.Second
+
+// MCPServerMsg is sent once an MCP server was started (or failed to start)
+// and once a started server exited
+type MCPServerMsg struct {
+	Name   string
+	Client *mcp.Client
+	Tools  []mcp.Tool
+	Err    error
+	Exited bool
+}
+
+// An MCP server of the chat
+type mcpServer struct {
+	name     string
+	client   *mcp.Client
+	tools    []string // the names of the registered tools
+	err      error
+	starting bool
+}
+
+// Returns the names of the configured MCP servers of the chat, the global
+// ones and the ones enabled in its settings
+func mcpServerNames(settings client.Chat) []string {
+	names := []string{}
+	for name, server := range config.Current.MCPServers {
+		if server.Global {
+			names = append(names, name)
+		}
+	}
+
+	for _, name := range strings.Split(settings.MCPServers, ",") {
+		name = strings.TrimSpace(name)
+		if _, ok := config.Current.MCPServers[name]; ok && !slices.Contains(names, name) {
+			names = append(names, name)
+		}
+	}
+
+	slices.Sort(names)
+	return names
+}
+
+// Returns the names of the configured MCP servers that are enabled per chat
+func optionalMCPServers() []string {
+	names := []string{}
+	for _, name := range slices.Sorted(maps.Keys(config.Current.MCPServers)) {
+		if !config.Current.MCPServers[name].Global {
+			names = append(names, name)
+		}
+	}
+
+	return names
+}
+
+// Starts the MCP servers of the chat that are not running yet and stops the
+// ones that are no longer enabled
+func (chat *Chat) syncMCPServers() tea.Cmd {
+	wanted := mcpServerNames(chat.ChatSettings)
+
+	servers := []*mcpServer{}
+	for _, server := range chat.mcpServers {
+		if slices.Contains(wanted, server.name) {
+			servers = append(servers, server)
+		} else {
+			server.stop()
+		}
+	}
+
+	cmds := []tea.Cmd{}
+	for _, name := range wanted {
+		if slices.ContainsFunc(servers, func(server *mcpServer) bool { return server.name == name }) {
+			continue
+		}
+
+		servers = append(servers, &mcpServer{name: name, starting: true})
+		cmds = append(cmds, startMCPServer(name, config.Current.MCPServers[name]))
+	}
+
+	slices.SortFunc(servers, func(a, b *mcpServer) int {
+		return strings.Compare(a.name, b.name)
+	})
+	chat.mcpServers = servers
+
+	return tea.Batch(cmds...)
+}
+
+// Launches the MCP server and lists its tools in the background
+func startMCPServer(name string, server config.MCPServer) tea.Cmd {
+	return func() tea.Msg {
+		mcpClient, err := mcp.Start(context.Background(), name, server)
+		if err != nil {
+			return MCPServerMsg{Name: name, Err: err}
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), mcpListTimeout)
+		defer cancel()
+
+		serverTools, err := mcpClient.ListTools(ctx)
+		if err != nil {
+			mcpClient.Close()
+			return MCPServerMsg{Name: name, Err: fmt.Errorf("could not list the tools: %w", err)}
+		}
+
+		return MCPServerMsg{Name: name, Client: mcpClient, Tools: serverTools}
+	}
+}
+
+// Registers the tools of the started server, a server that is no longer
+// enabled is stopped right away
+func (chat *Chat) finishMCPServer(msg MCPServerMsg) tea.Cmd {
+	index := slices.IndexFunc(chat.mcpServers, func(server *mcpServer) bool {
+		return server.name == msg.Name && server.starting
+	})
+	if index < 0 {
+		if msg.Client != nil {
+			go msg.Client.Close()
+		}
+		return nil
+	}
+
+	server := chat.mcpServers[index]
+	server.starting = false
+
+	if msg.Err != nil {
+		server.err = msg.Err
+		return chat.notify(fmt.Sprintf("Could not start the MCP server %s: %v", msg.Name, msg.Err))
+	}
+
+	server.client = msg.Client
+	server.tools = mcp.RegisterTools(msg.Client, msg.Tools, config.Current.MCPServers[msg.Name].Approve)
+
+	return watchMCPServer(msg.Name, msg.Client)
+}
+
+// Waits for the started server to exit
+func watchMCPServer(name string, mcpClient *mcp.Client) tea.Cmd {
+	return func() tea.Msg {
+		<-mcpClient.Done()
+		return MCPServerMsg{Name: name, Client: mcpClient, Err: mcpClient.Err(), Exited: true}
+	}
+}
+
+// Marks the server that exited as failed and unregisters its tools, the
+// servers that were stopped (or restarted) are ignored
+func (chat *Chat) exitMCPServer(msg MCPServerMsg) tea.Cmd {
+	index := slices.IndexFunc(chat.mcpServers, func(server *mcpServer) bool {
+		return server.client == msg.Client
+	})
+	if index < 0 {
+		return nil
+	}
+
+	server := chat.mcpServers[index]
+	mcp.UnregisterTools(server.tools)
+	server.tools = nil
+	server.client = nil
+	server.err = msg.Err
+
+	return chat.notify(fmt.Sprintf("The MCP server %s stopped: %v", msg.Name, msg.Err))
+}
+
+// Unregisters the tools of the server and stops it in the background
+func (server *mcpServer) stop() {
+	mcp.UnregisterTools(server.tools)
+	server.tools = nil
+
+	if server.client != nil {
+		go server.client.Close()
+		server.client = nil
+	}
+}
+
+// Restarts the named MCP server of the chat (every server if name is empty)
+func (chat *Chat) restartMCPServers(name string) tea.Cmd {
+	servers := []*mcpServer{}
+	for _, server := range chat.mcpServers {
+		if name == "" || server.name == name {
+			server.stop()
+		} else {
+			servers = append(servers, server)
+		}
+	}
+	chat.mcpServers = servers
+
+	return chat.syncMCPServers()
+}
+
+// Close stops the MCP servers of the chat
+func (chat *Chat) Close() {
+	for _, server := range chat.mcpServers {
+		mcp.UnregisterTools(server.tools)
+		if server.client != nil {
+			server.client.Close()
+		}
+	}
+	chat.mcpServers = nil
+}
+
+// Returns the names of the tools the model can call, the built-in tools
+// enabled for the chat and the tools of its running MCP servers
+func (chat *Chat) availableTools() []string {
+	names := enabledTools(chat.ChatSettings)
+	for _, server := range chat.mcpServers {
+		names = append(names, server.tools...)
+	}
+
+	return names
+}
+
+// Returns the status of the server, e.g. "running, 4 tools"
+func (server *mcpServer) status() string {
+	switch {
+	case server.starting:
+		return "starting"
+	case server.err != nil:
+		return "failed: " + truncate.StringWithTail(server.err.Error(), 80, "…")
+	case len(server.tools) == 1:
+		return "running, 1 tool"
+	default:
+		return fmt.Sprintf("running, %d tools", len(server.tools))
+	}
+}
+
+// Renders the MCP servers of the chat on the help line, the dot of each
+// server shows if it is starting, running or failed
+func (chat *Chat) mcpStatusView() string {
+	if len(chat.mcpServers) == 0 {
+		return ""
+	}
+
+	parts := []string{}
+	for _, server := range chat.mcpServers {
+		color := activeColor
+		switch {
+		case server.starting:
+			color = noticeColor
+		case server.err != nil:
+			color = dangerColor
+		}
+
+		parts = append(parts, lipgloss.NewStyle().Foreground(color).Render("●")+helpStyle(" "+server.name))
+	}
+
+	return helpStyle("mcp ") + strings.Join(parts, " ") + "  "
+}
+
+// Shows the status of the MCP servers, "/mcp restart [name]" restarts them
+func (chat *Chat) mcpCommand(args string) tea.Cmd {
+	action, name, _ := strings.Cut(args, " ")
+
+	switch action {
+	case "":
+		return chat.notify(chat.mcpStatusNotification())
+	case "restart":
+		name = strings.TrimSpace(name)
+		if name != "" && !slices.ContainsFunc(chat.mcpServers, func(server *mcpServer) bool { return server.name == name }) {
+			return chat.notify("The MCP server " + name + " is not enabled for this chat")
+		}
+		return tea.Batch(chat.restartMCPServers(name), chat.notify("Restarting the MCP servers..."))
+	}
+
+	return chat.notify("Usage: /mcp [restart [name]]")
+}
+
+// Lists the MCP servers of the chat and their status in a notification
+func (chat *Chat) mcpStatusNotification() string {
+	if len(chat.mcpServers) == 0 {
+		return "No MCP servers are enabled, add them to the mcp_servers of the config file"
+	}
+
+	lines := []string{}
+	for _, server := range chat.mcpServers {
+		lines = append(lines, HighlightForegroundStyle.Render(server.name)+" "+server.status())
+	}
+
+	return strings.Join(lines, "\n")
+}
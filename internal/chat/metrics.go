@@ -0,0 +1,67 @@
+package chat
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	oapi "github.com/ollama/ollama/api"
+)
+
+// Generation metrics of an assistant message, as reported by Ollama in the
+// final chunk of the response (plus the time to the first token, measured by
+// gollama)
+type MessageMetrics struct {
+	TotalDuration      time.Duration
+	LoadDuration       time.Duration
+	PromptEvalDuration time.Duration
+	EvalDuration       time.Duration
+	TimeToFirstToken   time.Duration
+	PromptEvalCount    int
+	EvalCount          int
+}
+
+// StreamMetrics is sent with the metrics of the final chunk of a response
+type StreamMetrics MessageMetrics
+
+// Creates the message metrics from the metrics of the final response chunk
+func newMessageMetrics(metrics oapi.Metrics, timeToFirstToken time.Duration) MessageMetrics {
+	return MessageMetrics{
+		TotalDuration:      metrics.TotalDuration,
+		LoadDuration:       metrics.LoadDuration,
+		PromptEvalDuration: metrics.PromptEvalDuration,
+		EvalDuration:       metrics.EvalDuration,
+		TimeToFirstToken:   timeToFirstToken,
+		PromptEvalCount:    metrics.PromptEvalCount,
+		EvalCount:          metrics.EvalCount,
+	}
+}
+
+// Returns the generation speed in tokens per second
+func (m MessageMetrics) TokensPerSecond() float64 {
+	if m.EvalDuration <= 0 {
+		return 0
+	}
+	return float64(m.EvalCount) / m.EvalDuration.Seconds()
+}
+
+// Formats the metrics for the footer of the message bubble
+func (m MessageMetrics) String() string {
+	parts := []string{}
+
+	if tps := m.TokensPerSecond(); tps > 0 {
+		parts = append(parts, fmt.Sprintf("%.1f tok/s", tps))
+	}
+
+	if m.TimeToFirstToken > 0 {
+		parts = append(parts, fmt.Sprintf("ttft %s", m.TimeToFirstToken.Round(10*time.Millisecond)))
+	}
+
+	parts = append(parts, fmt.Sprintf("%d prompt / %d output tokens", m.PromptEvalCount, m.EvalCount))
+
+	if m.LoadDuration > 100*time.Millisecond {
+		parts = append(parts, fmt.Sprintf("load %s", m.LoadDuration.Round(10*time.Millisecond)))
+	}
+
+	return strings.Join(parts, " • ")
+}
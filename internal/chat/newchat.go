@@ -2,7 +2,6 @@ package chat
 
 import (
 	"fmt"
-	"strings"
 	"time"
 
 	tea "github.com/charmbracelet/bubbletea"
@@ -16,30 +15,16 @@ import (
 // Huh form for creating a new chat, returns the new chat settings
 // TODO: add more Ollama settings and sliders (temperature, max tokens, etc.)
 func NewChatSettingsForm() (client.Chat, error) {
-	var newChatSettings client.Chat
+	newChatSettings := client.Chat{
+		ContextStrategy: ContextStrategyNone,
+		KeepTurns:       defaultKeepTurns,
+	}
 
 	form := huh.NewForm(
 		huh.NewGroup(
-			huh.NewInput().
-				Description("Chat Title").
-				Placeholder("Chat Title").
-				Validate(func(title string) error {
-					trimmedTitle := strings.TrimSpace(title)
-					if trimmedTitle == "" {
-						return fmt.Errorf("Chat Title cannot be empty")
-					}
-
-					return nil
-				}).
-				Value(&newChatSettings.ChatTitle),
-			huh.NewText().
-				Title("System Message").
-				Placeholder("(Optional) Leave empty if you don't want to set a system message.").
-				Value(&newChatSettings.SystemMessage),
-			huh.NewConfirm().
-				Title("Anonymous Chat").
-				Description("Do you want to create an anonymous chat? (Messages will not be saved)").
-				Value(&newChatSettings.IsAnonymous),
+			chatTitleInput(&newChatSettings.ChatTitle),
+			systemMessageText(&newChatSettings.SystemMessage),
+			anonymousConfirm(&newChatSettings.IsAnonymous),
 		),
 	).WithProgramOptions(tea.WithAltScreen())
 
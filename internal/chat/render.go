@@ -0,0 +1,414 @@
+package chat
+
+import (
+	"fmt"
+	"hash/fnv"
+	"strings"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/glamour"
+	"github.com/charmbracelet/lipgloss"
+	"github.com/gaurav-gosain/gollama/internal/roles"
+)
+
+// the minimum time between two repaints of a streamed response, the chunks
+// received in between are rendered together
+const streamFrameInterval = time.Second / 30
+
+// streamRepaintMsg is sent when the throttled repaint of the streamed
+// response is due
+type streamRepaintMsg struct{}
+
+// The rendered stable prefix of the streamed response (without the final line
+// break), it grows block by block and is only rendered from scratch once the
+// width changes
+type renderedPrefix struct {
+	markdown string
+	width    int
+	rendered string
+}
+
+// Renders markdown with a glamour renderer per word wrap width, a renderer
+// must only be used by one goroutine at a time
+type markdownRenderer struct {
+	// the glamour style, the current theme's if nil (the renderers used in
+	// the background are given the style so they never read the theme)
+	style        glamour.TermRendererOption
+	renderers    map[int]*glamour.TermRenderer
+	margins      map[int]string // the line between two blocks per width
+	streamPrefix renderedPrefix
+}
+
+// Returns the markdown renderer for the word wrap width, renderers are
+// created once per width and reused
+func (md *markdownRenderer) renderer(width int) *glamour.TermRenderer {
+	if renderer, ok := md.renderers[width]; ok {
+		return renderer
+	}
+
+	style := md.style
+	if style == nil {
+		style = glamourStyle
+	}
+
+	renderer, err := glamour.NewTermRenderer(
+		style,
+		glamour.WithWordWrap(width),
+	)
+	if err != nil {
+		return nil
+	}
+
+	if md.renderers == nil {
+		md.renderers = map[int]*glamour.TermRenderer{}
+	}
+	md.renderers[width] = renderer
+
+	return renderer
+}
+
+// Renders the markdown wrapped to the width, the markdown is returned as is if
+// it can't be rendered
+func (md *markdownRenderer) render(markdown string, width int) string {
+	renderer := md.renderer(width)
+	if renderer == nil {
+		return markdown
+	}
+
+	rendered, err := renderer.Render(markdown)
+	if err != nil {
+		return markdown
+	}
+
+	return rendered
+}
+
+// Returns the length of the part of the markdown that can't change once more
+// text is appended, i.e. up to the last block that starts after a blank line
+// outside of a code block
+func stablePrefixLength(markdown string) int {
+	var (
+		cut    int
+		offset int
+		fence  string
+		blank  bool
+	)
+
+	for _, line := range strings.SplitAfter(markdown, "\n") {
+		trimmed := strings.TrimSpace(line)
+
+		if fence == "" {
+			// indented lines might continue the previous block (e.g. a list),
+			// so might list items (a loose list)
+			if blank && trimmed != "" && !strings.HasPrefix(line, " ") && !strings.HasPrefix(line, "\t") && !isListItem(trimmed) {
+				cut = offset
+			}
+
+			if strings.HasPrefix(trimmed, "```") || strings.HasPrefix(trimmed, "~~~") {
+				fence = trimmed[:len(trimmed)-len(strings.TrimLeft(trimmed, trimmed[:1]))]
+			}
+		} else if strings.HasPrefix(trimmed, fence) && strings.Trim(trimmed, fence[:1]) == "" {
+			fence = ""
+		}
+
+		blank = fence == "" && trimmed == ""
+		offset += len(line)
+	}
+
+	return cut
+}
+
+// Returns true if the (trimmed) line starts a list item, e.g. "- item" or
+// "1. item", or might once more text is streamed (e.g. "1")
+func isListItem(line string) bool {
+	bullet := strings.TrimLeft(line, "-*+")
+	if len(line)-len(bullet) == 1 && (bullet == "" || strings.HasPrefix(bullet, " ")) {
+		return true
+	}
+
+	digits := len(line) - len(strings.TrimLeft(line, "0123456789"))
+	if digits == 0 {
+		return false
+	}
+
+	rest := line[digits:]
+	return rest == "" || rest == "." || rest == ")" ||
+		strings.HasPrefix(rest, ". ") || strings.HasPrefix(rest, ") ")
+}
+
+// Renders the markdown of the streamed response, the stable prefix is cached
+// so only the tail is rendered for each chunk
+func (md *markdownRenderer) renderStreaming(markdown string, width int) string {
+	cut := stablePrefixLength(markdown)
+	if cut == 0 {
+		return md.render(markdown, width)
+	}
+
+	prefix := markdown[:cut]
+	cached := md.streamPrefix
+	switch {
+	case cached.markdown == prefix && cached.width == width:
+	case cached.markdown != "" && cached.width == width && strings.HasPrefix(prefix, cached.markdown):
+		// only the blocks that became stable since the last chunk are rendered
+		md.streamPrefix = renderedPrefix{
+			markdown: prefix,
+			width:    width,
+			rendered: strings.TrimSuffix(md.joinRendered(cached.rendered, md.render(prefix[len(cached.markdown):], width), width), "\n"),
+		}
+	default:
+		md.streamPrefix = renderedPrefix{
+			markdown: prefix,
+			width:    width,
+			rendered: strings.TrimSuffix(md.render(prefix, width), "\n"),
+		}
+	}
+
+	return md.joinRendered(md.streamPrefix.rendered, md.render(markdown[cut:], width), width)
+}
+
+// Joins the rendered blocks like glamour does when they are rendered
+// together, head is rendered markdown without its final line break. The
+// blocks are separated by the margin line, the blocks that start with their
+// own margin (e.g. lists and code) are not separated again
+func (md *markdownRenderer) joinRendered(head, tail string, width int) string {
+	margin := md.blockMargin(width)
+	if strings.HasPrefix(tail, "\n"+margin+"\n") {
+		return head + tail[1:]
+	}
+
+	return head + margin + tail
+}
+
+// Returns the line glamour renders between two paragraphs
+func (md *markdownRenderer) blockMargin(width int) string {
+	if margin, ok := md.margins[width]; ok {
+		return margin
+	}
+
+	margin := ""
+	// the paragraphs are on the second and fourth lines
+	if lines := strings.Split(md.render("a\n\nb", width), "\n"); len(lines) > 3 {
+		margin = lines[2]
+	}
+
+	if md.margins == nil {
+		md.margins = map[int]string{}
+	}
+	md.margins[width] = margin
+
+	return margin
+}
+
+// Repaints the streamed response now, or schedules the repaint for the next
+// frame if the last one was too recent
+func (chat *Chat) scheduleRepaint() tea.Cmd {
+	elapsed := time.Since(chat.lastRepaint)
+	if elapsed >= streamFrameInterval {
+		chat.repaintStream()
+		return nil
+	}
+
+	if chat.repaintPending {
+		return nil
+	}
+
+	chat.repaintPending = true
+	return tea.Tick(streamFrameInterval-elapsed, func(_ time.Time) tea.Msg {
+		return streamRepaintMsg{}
+	})
+}
+
+// Renders the streamed response and scrolls to the bottom
+func (chat *Chat) repaintStream() {
+	last := len(chat.ChatHistory) - 1
+	chat.chatState[len(chat.chatState)-1] = chat.getMessageBubble(chat.ChatHistory[last], false, fmt.Sprintf("%d", last))
+	chat.updateViewport()
+	chat.lastRepaint = time.Now()
+}
+
+const (
+	// the number of bubbles kept in the render cache, it starts over once it
+	// is full (e.g. after many resizes)
+	maxCachedBubbles = 4096
+	// the number of bubbles rendered in the background before the viewport is
+	// updated
+	renderBatchSize = 8
+)
+
+// A bubble that is rendered in the background, index is its position in the
+// chat state
+type bubbleJob struct {
+	index int
+	key   uint64
+	spec  bubbleSpec
+}
+
+// bubblesRenderedMsg is sent once a batch of bubbles is rendered in the
+// background, the remaining jobs are rendered by the next batch
+type bubblesRenderedMsg struct {
+	generation int
+	jobs       []bubbleJob
+	bubbles    []string
+	remaining  []bubbleJob
+	markdown   *markdownRenderer
+}
+
+// Returns the key of the bubble in the render cache, it changes whenever the
+// rendered bubble would (every input of the bubble is hashed)
+func (spec bubbleSpec) key() uint64 {
+	h := fnv.New64a()
+
+	fmt.Fprintf(
+		h,
+		"%s\x00%s\x00%s\x00%d\x00%t\x00%t\x00%t\x00%s\x00%s\x00%s\x00",
+		spec.role,
+		spec.title,
+		spec.id,
+		spec.width,
+		spec.collapsed,
+		spec.selected,
+		spec.streaming,
+		spec.theme.name,
+		spec.body,
+		spec.message,
+	)
+	for _, file := range spec.files {
+		fmt.Fprintf(h, "%s\x00%d\x00%t\x00", file.Path, file.Size, file.Truncated)
+	}
+	// the placeholders are replaced once the images are rendered
+	for _, image := range spec.images {
+		_, rendered := cachedImage(image)
+		fmt.Fprintf(h, "%s\x00%d\x00%d\x00%t\x00", image.path, image.modTime, image.height, rendered)
+	}
+
+	return h.Sum64()
+}
+
+// Stores the rendered bubble in the render cache
+func (chat *Chat) cacheBubble(key uint64, bubble string) {
+	if chat.bubbleCache == nil || len(chat.bubbleCache) >= maxCachedBubbles {
+		chat.bubbleCache = map[uint64]string{}
+	}
+	chat.bubbleCache[key] = bubble
+}
+
+// Returns a bubble with roughly the height of the rendered one, it is shown
+// until the message is rendered in the background
+func (chat *Chat) placeholderBubble(msg *ChatNode, id string) string {
+	spec := chat.bubbleSpec(msg, false, id)
+
+	width := spec.width / 2
+	if spec.width < 80 {
+		width = spec.width - 6
+	}
+	wrap := max(1, width-4)
+
+	// the rendered markdown has a blank line above and below it
+	lines := 2
+	for _, line := range strings.Split(spec.body, "\n") {
+		lines += max(1, (lipgloss.Width(line)+wrap-1)/wrap)
+	}
+	if !spec.collapsed {
+		if len(spec.files) > 0 {
+			lines += len(spec.files) + 1
+		}
+		for _, image := range spec.images {
+			lines += image.height - 1
+		}
+	}
+
+	align := lipgloss.Right
+	if spec.role == roles.ASSISTANT || spec.role == roles.TOOL {
+		align = lipgloss.Left
+	}
+
+	bubble := addToBorder(
+		RoundedBorder.
+			Border(lipgloss.RoundedBorder(), false, true, true).
+			Width(min(width+4, max(len(spec.title)+4, lipgloss.Width(spec.body)+4))).
+			Height(lines).
+			Padding(0, 2).
+			Foreground(mutedColor).
+			BorderForeground(mutedColor).
+			Render("rendering…"),
+		HighlightStyle.Render(spec.title),
+		mutedColor,
+		spec.id,
+	)
+
+	return lipgloss.NewStyle().Width(spec.width).Align(align).Render(bubble)
+}
+
+// Renders the jobs in batches in the background, each batch is sent to the
+// chat once it is done
+func renderInBackground(generation int, jobs []bubbleJob, md *markdownRenderer) tea.Cmd {
+	if len(jobs) == 0 {
+		return nil
+	}
+
+	return func() tea.Msg {
+		batch := jobs[:min(renderBatchSize, len(jobs))]
+
+		bubbles := make([]string, len(batch))
+		for i, job := range batch {
+			bubbles[i] = md.bubble(job.spec)
+		}
+
+		return bubblesRenderedMsg{
+			generation: generation,
+			jobs:       batch,
+			bubbles:    bubbles,
+			remaining:  jobs[len(batch):],
+			markdown:   md,
+		}
+	}
+}
+
+// Replaces the placeholders with the bubbles rendered in the background and
+// starts the next batch, the viewport keeps showing the same messages
+func (chat *Chat) applyRenderedBubbles(msg bubblesRenderedMsg) tea.Cmd {
+	// the chat was rendered again since (e.g. resized or the theme was
+	// switched), its own jobs replace the remaining ones and the stale bubbles
+	// are not cached
+	if msg.generation != chat.renderGeneration {
+		return nil
+	}
+
+	for i, job := range msg.jobs {
+		chat.cacheBubble(job.key, msg.bubbles[i])
+	}
+
+	atBottom := chat.viewport.AtBottom()
+	yOffset := chat.viewport.YOffset
+	shift := 0
+
+	for i, job := range msg.jobs {
+		if job.index >= len(chat.chatState) || chat.chatState[job.index] != "" {
+			continue
+		}
+
+		node := chat.ChatHistory[job.index]
+		bubble := msg.bubbles[i]
+		// the message changed while it was rendered
+		if chat.bubbleSpec(node, false, job.spec.id).key() != job.key {
+			bubble = chat.getMessageBubble(node, false, job.spec.id)
+		}
+
+		// the messages above the viewport must not push the visible ones down
+		if job.index < len(chat.messageOffsets) && chat.messageOffsets[job.index] < yOffset {
+			shift += lipgloss.Height(bubble) - lipgloss.Height(chat.placeholderBubble(node, job.spec.id))
+		}
+
+		chat.chatState[job.index] = bubble
+	}
+
+	chat.redrawViewport()
+	if atBottom {
+		chat.viewport.GotoBottom()
+	} else {
+		chat.viewport.SetYOffset(yOffset + shift)
+	}
+
+	return renderInBackground(msg.generation, msg.remaining, msg.markdown)
+}
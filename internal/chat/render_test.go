@@ -0,0 +1,100 @@
+package chat
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// the word wrap width of the rendered replies
+const testWrapWidth = 60
+
+// A reply with the blocks the models usually write, headings, paragraphs,
+// nested and loose lists, fenced code, tables and quotes
+const testReply = "## Setting up the server\n" +
+	"\n" +
+	"The server reads its **configuration** from `config.yaml`, the defaults are fine for a _local_ setup.\n" +
+	"\n" +
+	"1. Install the dependencies\n" +
+	"2. Create the database\n" +
+	"   - run the migrations\n" +
+	"   - seed the tables\n" +
+	"3. Start the server\n" +
+	"\n" +
+	"```go\n" +
+	"func main() {\n" +
+	"\tserver := NewServer(\"localhost:8080\")\n" +
+	"\n" +
+	"\tif err := server.Run(); err != nil {\n" +
+	"\t\tlog.Fatal(err)\n" +
+	"\t}\n" +
+	"}\n" +
+	"```\n" +
+	"\n" +
+	"| Option | Default | Description |\n" +
+	"| ------ | ------- | ----------- |\n" +
+	"| port | 8080 | The port to listen on |\n" +
+	"| debug | false | Logs every request |\n" +
+	"\n" +
+	"> The port can also be set with the `PORT` environment variable.\n" +
+	"\n" +
+	"- a list after the quote\n" +
+	"- with a [link](https://example.com)\n" +
+	"\n" +
+	"- and a loose item\n" +
+	"\n" +
+	"~~~sh\n" +
+	"go run .\n" +
+	"~~~\n" +
+	"\n" +
+	"That's it!\n" +
+	"\n" +
+	"A last paragraph.\n"
+
+// Returns a reply of several thousand tokens made of numbered copies of
+// testReply
+func longTestReply(tokens int) string {
+	var b strings.Builder
+	for i := 1; estimateTokens(b.String()) < tokens; i++ {
+		fmt.Fprintf(&b, "# Part %d\n\n%s\n", i, testReply)
+	}
+
+	return b.String()
+}
+
+// The streamed rendering of every prefix of the reply must be identical to the
+// full rendering of the prefix
+func TestRenderStreamingMatchesRender(t *testing.T) {
+	md := &markdownRenderer{}
+	full := &markdownRenderer{}
+
+	for i := 1; i <= len(testReply); i++ {
+		prefix := fixMarkdown(testReply[:i])
+
+		streamed := md.renderStreaming(prefix, testWrapWidth)
+		rendered := full.render(prefix, testWrapWidth)
+
+		if streamed != rendered {
+			t.Fatalf(
+				"the streamed rendering of the first %d bytes differs\nmarkdown:\n%s\nstreamed:\n%q\nrendered:\n%q",
+				i,
+				prefix,
+				streamed,
+				rendered,
+			)
+		}
+	}
+}
+
+// Streams a reply of ~4k tokens in small chunks, like the models send them
+func BenchmarkRenderStreaming(b *testing.B) {
+	reply := longTestReply(4000)
+	const chunkSize = 16
+
+	for range b.N {
+		md := &markdownRenderer{}
+		for end := chunkSize; end < len(reply)+chunkSize; end += chunkSize {
+			md.renderStreaming(fixMarkdown(reply[:min(end, len(reply))]), testWrapWidth)
+		}
+	}
+}
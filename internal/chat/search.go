@@ -0,0 +1,323 @@
+package chat
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/charmbracelet/bubbles/key"
+	"github.com/charmbracelet/bubbles/textinput"
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+)
+
+// ANSI sequences used to highlight the search matches, reverse video keeps the
+// colors of the glamour output readable
+const (
+	matchStart        = "\x1b[7m"
+	matchEnd          = "\x1b[27m"
+	currentMatchStart = "\x1b[7;4m"
+	currentMatchEnd   = "\x1b[27;24m"
+)
+
+// A single occurrence of the search query in the chat history
+type searchMatch struct {
+	message    int // index of the message in the chat history
+	occurrence int // index of the occurrence within the message
+	line       int // line of the occurrence within the rendered message
+}
+
+// Creates the text input used for the search prompt
+func newSearchInput() textinput.Model {
+	input := textinput.New()
+	input.Prompt = "/"
+	input.Placeholder = "Search messages..."
+	input.PromptStyle = HighlightForegroundStyle
+	return input
+}
+
+// Opens the search prompt
+func (chat *Chat) openSearch() tea.Cmd {
+	chat.searching = true
+	chat.searchInput.SetValue(chat.searchQuery)
+	chat.searchInput.CursorEnd()
+	return chat.searchInput.Focus()
+}
+
+// Closes the search prompt and clears the highlighted matches
+func (chat *Chat) closeSearch() {
+	chat.searching = false
+	chat.searchInput.Blur()
+	chat.searchQuery = ""
+	chat.searchMatches = nil
+	chat.searchIndex = 0
+	chat.redrawViewport()
+}
+
+// Handles the key presses while searching (or browsing the search results),
+// returns false if the key should be handled by the chat instead
+func (chat *Chat) handleSearchKeys(msg tea.KeyMsg) (bool, tea.Cmd) {
+	if chat.searching {
+		switch {
+		case key.Matches(msg, Keys.Close):
+			chat.closeSearch()
+			return true, nil
+		case key.Matches(msg, Keys.Submit):
+			chat.searching = false
+			chat.searchInput.Blur()
+			chat.searchQuery = chat.searchInput.Value()
+			chat.searchIndex = 0
+			chat.redrawViewport()
+
+			if chat.searchQuery == "" {
+				return true, nil
+			}
+
+			if len(chat.searchMatches) == 0 {
+				query := chat.searchQuery
+				chat.closeSearch()
+				return true, chat.notify(fmt.Sprintf("No matches for %q", query))
+			}
+
+			chat.jumpToMatch(0)
+			return true, nil
+		case isForceQuit(msg):
+			return false, nil
+		}
+
+		var cmd tea.Cmd
+		chat.searchInput, cmd = chat.searchInput.Update(msg)
+		return true, cmd
+	}
+
+	if chat.searchQuery == "" {
+		return false, nil
+	}
+
+	switch {
+	case key.Matches(msg, Keys.NextMatch):
+		chat.jumpToMatch(chat.searchIndex + 1)
+	case key.Matches(msg, Keys.PreviousMatch):
+		chat.jumpToMatch(chat.searchIndex - 1)
+	case key.Matches(msg, Keys.NewSearch, Keys.Search):
+		return true, chat.openSearch()
+	case key.Matches(msg, Keys.Submit, Keys.Close):
+		chat.closeSearch()
+	case isForceQuit(msg):
+		return false, nil
+	}
+
+	// the prompt is not focused while browsing the results
+	return true, nil
+}
+
+// Moves the highlight (and the viewport) to the match with the provided index,
+// wrapping around at both ends
+func (chat *Chat) jumpToMatch(index int) {
+	if len(chat.searchMatches) == 0 {
+		return
+	}
+
+	total := len(chat.searchMatches)
+	chat.searchIndex = (index%total + total) % total
+
+	match := chat.searchMatches[chat.searchIndex]
+	chat.highlightedChatIndex = match.message
+	chat.redrawViewport()
+
+	chat.viewport.SetYOffset(max(0, chat.messageOffsets[match.message]+match.line-chat.viewport.Height/2))
+}
+
+// Highlights the search matches of the message in its rendered bubble,
+// returns the highlighted bubble and the matches of the message. The matches
+// are found in the visible text of the rendered lines (without the markdown
+// markup), so the highlighted occurrences are the ones n/N jump to
+func (chat *Chat) highlightSearchMatches(rendered string, messageIndex int) (string, []searchMatch) {
+	current := -1
+	if chat.searchIndex < len(chat.searchMatches) &&
+		chat.searchMatches[chat.searchIndex].message == messageIndex {
+		current = chat.searchMatches[chat.searchIndex].occurrence
+	}
+
+	matches := []searchMatch{}
+	lines := strings.Split(rendered, "\n")
+
+	// the first line is the border with the title of the bubble
+	for i := 1; i < len(lines); i++ {
+		var count int
+		lines[i], count = highlightLine(lines[i], chat.searchQuery, len(matches), current)
+		for range count {
+			matches = append(matches, searchMatch{
+				message:    messageIndex,
+				occurrence: len(matches),
+				line:       i,
+			})
+		}
+	}
+
+	return strings.Join(lines, "\n"), matches
+}
+
+// A (case-insensitive) occurrence of the query, the start and end (exclusive)
+// rune index in the searched text
+type matchSpan struct{ start, end int }
+
+// Returns the occurrences of the needle in the (lower cased) text, they don't
+// overlap
+func occurrences(text, needle []rune) []matchSpan {
+	spans := []matchSpan{}
+	if len(needle) == 0 {
+		return spans
+	}
+
+	for i := 0; i+len(needle) <= len(text); {
+		if string(text[i:i+len(needle)]) == string(needle) {
+			spans = append(spans, matchSpan{i, i + len(needle)})
+			i += len(needle)
+			continue
+		}
+		i++
+	}
+
+	return spans
+}
+
+// Highlights the (case-insensitive) occurrences of the query in the visible
+// text of the line, ANSI sequences in the line are kept intact. first is the
+// number of occurrences before this line and current the one to highlight as
+// the current match
+func highlightLine(line, query string, first, current int) (string, int) {
+	needle := []rune(strings.ToLower(query))
+	if len(needle) == 0 {
+		return line, 0
+	}
+
+	// visible runes of the line and their byte offsets
+	visible := []rune{}
+	offsets := []int{}
+
+	for i := 0; i < len(line); {
+		if line[i] == '\x1b' {
+			i += ansiSequenceLength(line[i:])
+			continue
+		}
+
+		r, size := utf8.DecodeRuneInString(line[i:])
+
+		visible = append(visible, unicode.ToLower(r))
+		offsets = append(offsets, i)
+		i += size
+	}
+
+	spans := occurrences(visible, needle)
+	if len(spans) == 0 {
+		return line, 0
+	}
+
+	var b strings.Builder
+	spanIdx, inMatch := 0, false
+	start, end := matchStart, matchEnd
+
+	visibleIdx := 0
+	for i := 0; i < len(line); {
+		if line[i] == '\x1b' {
+			length := ansiSequenceLength(line[i:])
+			b.WriteString(line[i : i+length])
+			// a reset inside of the match would end the highlight early
+			if inMatch {
+				b.WriteString(start)
+			}
+			i += length
+			continue
+		}
+
+		if spanIdx < len(spans) && visibleIdx == spans[spanIdx].start {
+			start, end = matchStart, matchEnd
+			if first+spanIdx == current {
+				start, end = currentMatchStart, currentMatchEnd
+			}
+			b.WriteString(start)
+			inMatch = true
+		}
+
+		next := len(line)
+		if visibleIdx+1 < len(offsets) {
+			next = offsets[visibleIdx+1]
+		}
+		// copy the rune (escape sequences are handled in the next iteration)
+		runeEnd := i + 1
+		for runeEnd < next && line[runeEnd] != '\x1b' {
+			runeEnd++
+		}
+		b.WriteString(line[i:runeEnd])
+		i = runeEnd
+		visibleIdx++
+
+		if inMatch && visibleIdx == spans[spanIdx].end {
+			b.WriteString(end)
+			inMatch = false
+			spanIdx++
+		}
+	}
+
+	return b.String(), len(spans)
+}
+
+// Returns the length (in bytes) of the ANSI escape sequence at the start of s
+func ansiSequenceLength(s string) int {
+	if len(s) < 2 {
+		return len(s)
+	}
+
+	switch s[1] {
+	case '[': // CSI, ends with a byte in the range @ to ~
+		for i := 2; i < len(s); i++ {
+			if s[i] >= 0x40 && s[i] <= 0x7e {
+				return i + 1
+			}
+		}
+	case ']': // OSC, ends with BEL or ST
+		for i := 2; i < len(s); i++ {
+			if s[i] == '\a' {
+				return i + 1
+			}
+			if s[i] == '\x1b' && i+1 < len(s) && s[i+1] == '\\' {
+				return i + 2
+			}
+		}
+	default:
+		return 2
+	}
+
+	return len(s)
+}
+
+// Renders the search prompt (or the search results status) in place of the
+// help line below the prompt
+func (chat *Chat) searchView() string {
+	if chat.searching {
+		return lipgloss.JoinHorizontal(
+			lipgloss.Left,
+			" ",
+			chat.searchInput.View(),
+			keyHintsView(keyHint("search", Keys.Submit), keyHint("cancel", Keys.Close)),
+		)
+	}
+
+	status := fmt.Sprintf("%d/%d", chat.searchIndex+1, len(chat.searchMatches))
+
+	return lipgloss.JoinHorizontal(
+		lipgloss.Left,
+		" ",
+		HighlightStyle.Render("/"+chat.searchQuery),
+		" ",
+		HighlightActiveStyle.Render(status),
+		keyHintsView(
+			keyHint("next", Keys.NextMatch),
+			keyHint("previous", Keys.PreviousMatch),
+			keyHint("new search", Keys.NewSearch),
+			keyHint("close", Keys.Close),
+		),
+	)
+}
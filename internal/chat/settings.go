@@ -0,0 +1,410 @@
+package chat
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/charmbracelet/bubbles/key"
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/huh"
+	"github.com/charmbracelet/lipgloss"
+	"github.com/gaurav-gosain/gollama/internal/api"
+	"github.com/gaurav-gosain/gollama/internal/client"
+	"github.com/gaurav-gosain/gollama/internal/tools"
+	"github.com/gaurav-gosain/gollama/internal/utils"
+	oapi "github.com/ollama/ollama/api"
+)
+
+// Huh input for the chat title (shared by the new chat and settings forms)
+func chatTitleInput(title *string) *huh.Input {
+	return huh.NewInput().
+		Description("Chat Title").
+		Placeholder("Chat Title").
+		Validate(func(title string) error {
+			trimmedTitle := strings.TrimSpace(title)
+			if trimmedTitle == "" {
+				return fmt.Errorf("Chat Title cannot be empty")
+			}
+
+			return nil
+		}).
+		Value(title)
+}
+
+// Huh text field for the system message (shared by the new chat and settings
+// forms)
+func systemMessageText(systemMessage *string) *huh.Text {
+	return huh.NewText().
+		Title("System Message").
+		Placeholder("(Optional) Leave empty if you don't want to set a system message.").
+		Value(systemMessage)
+}
+
+// Huh confirm for the chat's anonymity (shared by the new chat and settings
+// forms)
+func anonymousConfirm(isAnonymous *bool) *huh.Confirm {
+	return huh.NewConfirm().
+		Title("Anonymous Chat").
+		Description("Do you want to create an anonymous chat? (Messages will not be saved)").
+		Value(isAnonymous)
+}
+
+// The chat settings being edited, the numeric settings are edited as strings
+// by the huh inputs
+type settingsDraft struct {
+	client.Chat
+	numCtx    string
+	keepTurns string
+	compactAt string
+	tools     []string
+	servers   []string
+
+	// a saved chat that becomes anonymous is only deleted once confirmed
+	wasAnonymous bool
+	deleteSaved  bool
+}
+
+// Creates the draft of the provided chat settings
+func newSettingsDraft(settings client.Chat) *settingsDraft {
+	draft := &settingsDraft{
+		Chat:         settings,
+		tools:        enabledTools(settings),
+		wasAnonymous: settings.IsAnonymous,
+	}
+	for _, name := range strings.Split(settings.MCPServers, ",") {
+		if name = strings.TrimSpace(name); slices.Contains(optionalMCPServers(), name) {
+			draft.servers = append(draft.servers, name)
+		}
+	}
+	if settings.NumCtx > 0 {
+		draft.numCtx = strconv.Itoa(settings.NumCtx)
+	}
+	if settings.KeepTurns > 0 {
+		draft.keepTurns = strconv.Itoa(settings.KeepTurns)
+	}
+	if settings.CompactAt > 0 {
+		draft.compactAt = strconv.Itoa(settings.CompactAt)
+	}
+	return draft
+}
+
+// Returns the edited chat settings (the inputs are validated by the form)
+func (draft *settingsDraft) settings() client.Chat {
+	settings := draft.Chat
+	settings.NumCtx, _ = strconv.Atoi(strings.TrimSpace(draft.numCtx))
+	settings.KeepTurns, _ = strconv.Atoi(strings.TrimSpace(draft.keepTurns))
+	if settings.KeepTurns <= 0 {
+		settings.KeepTurns = defaultKeepTurns
+	}
+	settings.CompactAt, _ = strconv.Atoi(strings.TrimSpace(draft.compactAt))
+	settings.Tools = strings.Join(draft.tools, ",")
+	settings.MCPServers = strings.Join(draft.servers, ",")
+	if !draft.wasAnonymous && !draft.deleteSaved {
+		settings.IsAnonymous = false
+	}
+	return settings
+}
+
+// Validates an optional, non-negative number input
+func validateCount(value string) error {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return nil
+	}
+	if n, err := strconv.Atoi(value); err != nil || n < 0 {
+		return fmt.Errorf("must be a positive number")
+	}
+	return nil
+}
+
+// Huh fields for the context window settings of the chat
+func contextSettingsFields(draft *settingsDraft) []huh.Field {
+	if draft.ContextStrategy == "" {
+		draft.ContextStrategy = ContextStrategyNone
+	}
+
+	return []huh.Field{
+		huh.NewInput().
+			Title("Context Window (num_ctx)").
+			Description("Leave empty to use the model's default").
+			Placeholder("e.g. 8192").
+			Validate(validateCount).
+			Value(&draft.numCtx),
+		huh.NewSelect[string]().
+			Title("Context Strategy").
+			Description("What to do when the conversation doesn't fit in the context window").
+			Options(
+				huh.NewOption("Send everything (the model forgets the oldest tokens)", ContextStrategyNone),
+				huh.NewOption("Sliding window (drop the oldest messages)", ContextStrategySliding),
+				huh.NewOption("System message and the last N turns", ContextStrategyPinned),
+				huh.NewOption("Refuse to send", ContextStrategyRefuse),
+			).
+			Value(&draft.ContextStrategy),
+		huh.NewInput().
+			Title("Turns To Keep").
+			Description("Used by the \"last N turns\" strategy").
+			Placeholder(strconv.Itoa(defaultKeepTurns)).
+			Validate(validateCount).
+			Value(&draft.keepTurns),
+		huh.NewInput().
+			Title("Auto Compact (%)").
+			Description("Summarize older messages once this share of the context window is used, leave empty to disable").
+			Placeholder("e.g. 80").
+			Validate(func(value string) error {
+				if err := validateCount(value); err != nil {
+					return err
+				}
+				if n, _ := strconv.Atoi(strings.TrimSpace(value)); n > 100 {
+					return fmt.Errorf("must be a percentage (at most 100)")
+				}
+				return nil
+			}).
+			Value(&draft.compactAt),
+	}
+}
+
+// Builds the form used to edit the settings of an existing chat
+func newSettingsForm(draft *settingsDraft, models []oapi.ListModelResponse) *huh.Form {
+	options := []huh.Option[string]{}
+	hasCurrent := false
+	for _, model := range models {
+		options = append(options, huh.NewOption(model.Name, model.Name))
+		hasCurrent = hasCurrent || model.Name == draft.ModelName
+	}
+
+	// the current model might not be installed anymore
+	if !hasCurrent {
+		options = append([]huh.Option[string]{
+			huh.NewOption(draft.ModelName, draft.ModelName),
+		}, options...)
+	}
+
+	toolFields := []huh.Field{
+		huh.NewConfirm().
+			Title("Allow Running Code").
+			Description("Shell code blocks of the replies can be run (each run has to be confirmed and is logged with the chat, so not in anonymous chats) and their output is sent to the model").
+			Value(&draft.AllowExec),
+		huh.NewMultiSelect[string]().
+			Title("Tools").
+			Description("The tools the model can call (the model has to support tools)").
+			Options(huh.NewOptions(tools.Builtin()...)...).
+			Value(&draft.tools),
+	}
+
+	// the global servers are always started
+	if servers := optionalMCPServers(); len(servers) > 0 {
+		toolFields = append(toolFields, huh.NewMultiSelect[string]().
+			Title("MCP Servers").
+			Description("The MCP servers started for this chat, the model can call their tools").
+			Options(huh.NewOptions(servers...)...).
+			Value(&draft.servers))
+	}
+
+	anonymous := anonymousConfirm(&draft.IsAnonymous)
+	if !draft.wasAnonymous {
+		anonymous.Description("Anonymous chats are not saved, the saved messages of this chat will be deleted")
+	}
+
+	return huh.NewForm(
+		huh.NewGroup(
+			chatTitleInput(&draft.ChatTitle),
+			systemMessageText(&draft.SystemMessage),
+			huh.NewSelect[string]().
+				Title("Model").
+				Options(options...).
+				Height(min(len(options)+2, 8)).
+				Value(&draft.ModelName),
+			anonymous,
+		),
+		huh.NewGroup(
+			contextSettingsFields(draft)...,
+		),
+		huh.NewGroup(
+			toolFields...,
+		),
+		// only shown when a saved chat is made anonymous
+		huh.NewGroup(
+			huh.NewConfirm().
+				Title("Delete The Saved Chat?").
+				Description("Making the chat anonymous deletes its messages, its execution log and its history file, this can't be undone").
+				Affirmative("Delete").
+				Negative("Keep It Saved").
+				Value(&draft.deleteSaved),
+		).WithHideFunc(func() bool {
+			return draft.wasAnonymous || !draft.IsAnonymous
+		}),
+	)
+}
+
+// Huh form for editing the settings of an existing chat (used by the chat
+// picker), returns the updated chat settings
+func EditChatSettingsForm(settings client.Chat) (client.Chat, error) {
+	models := []oapi.ListModelResponse{}
+
+	if ollamaAPI, err := api.NewOllamaAPI(); err == nil {
+		if list, err := ollamaAPI.Client.List(context.Background()); err == nil {
+			models = list.Models
+		}
+	}
+
+	draft := newSettingsDraft(settings)
+
+	form := newSettingsForm(draft, models).
+		WithProgramOptions(tea.WithAltScreen())
+
+	if err := form.Run(); err != nil {
+		return client.Chat{}, fmt.Errorf("error: %w", err)
+	}
+
+	updated := draft.settings()
+
+	for _, model := range models {
+		if model.Name == updated.ModelName && updated.ModelName != settings.ModelName {
+			updated.IsMultiModal = len(model.Details.Families) > 1
+		}
+	}
+
+	return ApplyChatSettings(settings, updated)
+}
+
+// Saves the updated settings of the chat, a chat that becomes anonymous is
+// deleted from the database (and its history from disk) while an anonymous
+// chat that should be saved is created. The updated settings are returned
+// with the error if the chat was deleted but not its history
+func ApplyChatSettings(previous, updated client.Chat) (client.Chat, error) {
+	switch {
+	case previous.IsAnonymous && !updated.IsAnonymous:
+		updated.ID = GenerateChatID()
+		if err := client.GollamaInstance.CreateChat(updated); err != nil {
+			return previous, fmt.Errorf("error creating chat: %w", err)
+		}
+	case !previous.IsAnonymous && updated.IsAnonymous:
+		if err := client.GollamaInstance.DeleteChat(previous.ID); err != nil {
+			return previous, err
+		}
+		updated.ID = ""
+		// the history is only removed once the chat is gone from the database
+		if err := os.Remove(HistoryPath(previous.ID)); err != nil && !os.IsNotExist(err) {
+			return updated, fmt.Errorf("error deleting chat history: %w", err)
+		}
+	case !updated.IsAnonymous:
+		if err := client.GollamaInstance.UpdateChat(updated); err != nil {
+			return previous, err
+		}
+	}
+
+	return updated, nil
+}
+
+// Opens the settings panel of the chat
+func (chat *Chat) openSettings() tea.Cmd {
+	chat.settingsDraft = newSettingsDraft(chat.ChatSettings)
+	chat.settingsForm = newSettingsForm(chat.settingsDraft, chat.models).
+		WithWidth(max(30, 6*chat.width/10)).
+		WithShowHelp(true)
+
+	return chat.settingsForm.Init()
+}
+
+// Closes the settings panel without saving
+func (chat *Chat) closeSettings() {
+	chat.settingsForm = nil
+	chat.settingsDraft = nil
+}
+
+// Routes the message to the settings panel, the settings are applied once the
+// form is completed
+func (chat *Chat) updateSettings(msg tea.Msg) tea.Cmd {
+	if msg, ok := msg.(tea.KeyMsg); ok && key.Matches(msg, Keys.Close) {
+		chat.closeSettings()
+		return nil
+	}
+
+	form, cmd := chat.settingsForm.Update(msg)
+	if f, ok := form.(*huh.Form); ok {
+		chat.settingsForm = f
+	}
+
+	switch chat.settingsForm.State {
+	case huh.StateAborted:
+		chat.closeSettings()
+	case huh.StateCompleted:
+		updated := chat.settingsDraft.settings()
+		chat.closeSettings()
+		return chat.applySettings(updated)
+	}
+
+	return cmd
+}
+
+// Applies the settings edited in the settings panel to the chat
+func (chat *Chat) applySettings(updated client.Chat) tea.Cmd {
+	if updated.ModelName != chat.modelName {
+		if model, ok := chat.findModel(updated.ModelName); ok {
+			updated.IsMultiModal = len(model.Details.Families) > 1
+		}
+	}
+
+	wasAnonymous := chat.ChatSettings.IsAnonymous
+
+	notification := "Updated the chat settings"
+
+	settings, err := ApplyChatSettings(chat.ChatSettings, updated)
+	if err != nil {
+		// the chat is anonymous already if only its history couldn't be removed
+		if settings.IsAnonymous == wasAnonymous {
+			return chat.notify(err.Error())
+		}
+		notification = err.Error()
+	}
+
+	chat.ChatSettings = settings
+	chat.modelName = settings.ModelName
+	chat.isMultiModal = settings.IsMultiModal
+
+	// a chat that is no longer anonymous needs its history written to disk
+	if wasAnonymous && !settings.IsAnonymous {
+		if err := chat.SaveHistory(); err != nil {
+			return chat.notify(err.Error())
+		}
+	}
+
+	return tea.Batch(
+		chat.focusPrompt(),
+		chat.notify(notification),
+		fetchContextLength(settings.ModelName),
+		chat.syncMCPServers(),
+	)
+}
+
+// Renders the settings panel on top of the provided content
+func (chat *Chat) settingsOverlayView(content string) string {
+	overlay := addToBorder(
+		layoutStyle.
+			Border(lipgloss.RoundedBorder(), false, true, true).
+			Padding(1, 2).
+			BorderForeground(primaryColor).
+			Render(
+				lipgloss.JoinVertical(
+					lipgloss.Left,
+					chat.settingsForm.View(),
+					"",
+					helpStyle(Keys.Close.Help().Key+" to close without saving"),
+				),
+			),
+		HighlightStyle.Render(" Chat Settings "),
+		primaryColor,
+		"",
+	)
+
+	return utils.PlaceOverlay(
+		(chat.width-lipgloss.Width(overlay))/2,
+		(chat.height-lipgloss.Height(overlay))/2,
+		overlay,
+		content,
+	)
+}
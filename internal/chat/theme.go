@@ -0,0 +1,173 @@
+package chat
+
+import (
+	"fmt"
+	"strings"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/glamour"
+	"github.com/charmbracelet/lipgloss"
+	"github.com/gaurav-gosain/gollama/internal/config"
+)
+
+// the colors of the current theme
+var (
+	primaryColor    lipgloss.Color
+	onPrimaryColor  lipgloss.Color
+	activeColor     lipgloss.Color
+	noticeColor     lipgloss.Color
+	foregroundColor lipgloss.Color
+	mutedColor      lipgloss.Color
+	contrastColor   lipgloss.Color
+	dangerColor     lipgloss.Color
+	helpKeyColor    lipgloss.Color
+)
+
+// the styles of the current theme
+var (
+	HighlightStyle           lipgloss.Style
+	NotificationStyle        lipgloss.Style
+	HighlightActiveStyle     lipgloss.Style
+	ErrorStyle               lipgloss.Style
+	HighlightForegroundStyle lipgloss.Style
+	DisabledHighlightStyle   lipgloss.Style
+	helpStyle                func(...string) string
+	// the glamour style of the messages
+	glamourStyle glamour.TermRendererOption
+	// the name of the current theme
+	themeName string
+)
+
+func init() {
+	SetTheme(config.DefaultTheme())
+}
+
+// The theme a bubble is rendered with, it is captured with the bubble's spec
+// so the bubbles rendered in the background never read the theme while it is
+// switched
+type bubbleTheme struct {
+	name        string
+	primary     lipgloss.Color
+	muted       lipgloss.Color
+	active      lipgloss.Color
+	foreground  lipgloss.Color
+	title       lipgloss.Style
+	activeTitle lipgloss.Style
+}
+
+// Returns the current theme of the bubbles
+func currentBubbleTheme() bubbleTheme {
+	return bubbleTheme{
+		name:        themeName,
+		primary:     primaryColor,
+		muted:       mutedColor,
+		active:      activeColor,
+		foreground:  foregroundColor,
+		title:       HighlightStyle,
+		activeTitle: HighlightActiveStyle,
+	}
+}
+
+// Sets the colors and styles of the chat (and the glamour style of the
+// messages) to the theme's
+func SetTheme(theme config.Theme) {
+	themeName = theme.Name
+
+	primaryColor = lipgloss.Color(theme.Primary)
+	onPrimaryColor = lipgloss.Color(theme.OnPrimary)
+	activeColor = lipgloss.Color(theme.Active)
+	noticeColor = lipgloss.Color(theme.Notice)
+	foregroundColor = lipgloss.Color(theme.Foreground)
+	mutedColor = lipgloss.Color(theme.Muted)
+	contrastColor = lipgloss.Color(theme.Contrast)
+	dangerColor = lipgloss.Color(theme.Danger)
+	helpKeyColor = lipgloss.Color(theme.HelpKey)
+
+	HighlightStyle = lipgloss.NewStyle().
+		Background(primaryColor).
+		Bold(true).
+		Padding(0, 1)
+	// the terminal's foreground is used if the theme doesn't set one
+	if theme.OnPrimary != "" {
+		HighlightStyle = HighlightStyle.Foreground(onPrimaryColor)
+	}
+
+	NotificationStyle = lipgloss.NewStyle().
+		Background(noticeColor).
+		Foreground(contrastColor).
+		Bold(true).
+		Padding(0, 1)
+
+	HighlightActiveStyle = lipgloss.NewStyle().
+		Background(activeColor).
+		Foreground(contrastColor).
+		Bold(true).
+		Padding(0, 1)
+
+	ErrorStyle = lipgloss.NewStyle().
+		Background(dangerColor).
+		Foreground(contrastColor).
+		Bold(true).
+		Padding(0, 1)
+
+	HighlightForegroundStyle = lipgloss.NewStyle().
+		Foreground(primaryColor).
+		Bold(true)
+
+	DisabledHighlightStyle = lipgloss.NewStyle().
+		Foreground(mutedColor).
+		Bold(true)
+
+	helpStyle = lipgloss.NewStyle().Foreground(lipgloss.Color(theme.Help)).PaddingLeft(1).Render
+
+	glamourStyle = theme.GlamourStyle()
+}
+
+// Switches the chat to the theme and saves it as the user's theme, every
+// message is rendered again
+func (chat *Chat) switchTheme(name string) tea.Cmd {
+	theme, err := config.LoadTheme(name)
+	if err != nil {
+		return chat.notify(fmt.Sprintf("Could not load the theme: %v", err))
+	}
+
+	// the bubbles being rendered in the background use the previous theme
+	chat.renderGeneration++
+	SetTheme(theme)
+
+	// the styles copied when the components were created
+	chat.help.Styles.FullKey = chat.help.Styles.FullKey.Foreground(helpKeyColor)
+	chat.searchInput.PromptStyle = HighlightForegroundStyle
+	chat.codeBlockInput.PromptStyle = HighlightForegroundStyle
+
+	// the cached bubbles (and renderers) use the previous theme
+	chat.markdown = markdownRenderer{}
+	chat.bubbleCache = nil
+
+	renderCmd := chat.renderChatState()
+	chat.updateViewport()
+
+	notification := fmt.Sprintf("Switched to the %s theme", name)
+	config.Current.Theme = name
+	if err := config.Save(); err != nil {
+		notification = fmt.Sprintf("Could not save the theme: %v", err)
+	}
+
+	// the prompt was created with the previous colors
+	return tea.Batch(renderCmd, chat.focusPrompt(), chat.notify(notification))
+}
+
+// Handles the /theme command, the current theme and the available ones are
+// shown if no theme is provided
+func (chat *Chat) themeCommand(args string) tea.Cmd {
+	if args == "" {
+		return chat.notify(fmt.Sprintf(
+			"The current theme is %s (%s), available themes: %s",
+			config.Current.Theme,
+			themeName,
+			strings.Join(config.ThemeNames(), ", "),
+		))
+	}
+
+	return chat.switchTheme(args)
+}
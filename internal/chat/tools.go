@@ -0,0 +1,326 @@
+package chat
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+
+	"github.com/charmbracelet/bubbles/key"
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+	"github.com/gaurav-gosain/gollama/internal/client"
+	"github.com/gaurav-gosain/gollama/internal/roles"
+	"github.com/gaurav-gosain/gollama/internal/tools"
+	"github.com/gaurav-gosain/gollama/internal/utils"
+	oapi "github.com/ollama/ollama/api"
+)
+
+// the number of responses in a row that can call tools, a model could keep
+// calling them forever
+const maxToolRounds = 10
+
+// A tool call of the model, the arguments are kept as JSON (gob can't encode
+// the decoded map)
+type ToolCall struct {
+	Name      string
+	Arguments string
+}
+
+// StreamToolCalls is sent with the tool calls of the streamed response
+type StreamToolCalls []ToolCall
+
+// ToolResultMsg is sent with the output of a tool once it ran
+type ToolResultMsg struct {
+	Call   ToolCall
+	Output string
+	Err    error
+}
+
+// Returns the names of the tools enabled for the chat
+func enabledTools(settings client.Chat) []string {
+	names := []string{}
+	for _, name := range strings.Split(settings.Tools, ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			names = append(names, name)
+		}
+	}
+
+	return names
+}
+
+// Converts the tool calls of the Ollama API
+func newToolCalls(calls []oapi.ToolCall) []ToolCall {
+	toolCalls := make([]ToolCall, len(calls))
+	for i, call := range calls {
+		arguments, _ := json.Marshal(call.Function.Arguments)
+		toolCalls[i] = ToolCall{
+			Name:      call.Function.Name,
+			Arguments: string(arguments),
+		}
+	}
+
+	return toolCalls
+}
+
+// Returns the decoded arguments of the call
+func (call ToolCall) args() tools.Args {
+	args := tools.Args{}
+	_ = json.Unmarshal([]byte(call.Arguments), &args)
+	return args
+}
+
+// Returns the call in the format of the Ollama API
+func (call ToolCall) request() oapi.ToolCall {
+	request := oapi.ToolCall{}
+	request.Function.Name = call.Name
+	request.Function.Arguments = oapi.ToolCallFunctionArguments(call.args())
+	return request
+}
+
+// Formats the call like a function call, e.g. read_file(path: "main.go")
+func (call ToolCall) String() string {
+	args := call.args()
+
+	params := []string{}
+	for _, name := range slices.Sorted(maps.Keys(args)) {
+		value, _ := json.Marshal(args[name])
+		params = append(params, fmt.Sprintf("%s: %s", name, value))
+	}
+
+	return fmt.Sprintf("%s(%s)", call.Name, strings.Join(params, ", "))
+}
+
+// Runs the tools called by the last response, their outputs are sent back to
+// the model once they all ran
+func (chat *Chat) startToolCalls() tea.Cmd {
+	chat.toolRounds++
+	if chat.toolRounds > maxToolRounds {
+		return tea.Batch(
+			chat.finishStreaming(),
+			chat.notify(fmt.Sprintf("The model called tools %d times in a row, the calls were stopped", maxToolRounds)),
+		)
+	}
+
+	chat.streaming = false
+	chat.cancelStream = nil
+	chat.markdown.streamPrefix = renderedPrefix{}
+
+	last := len(chat.ChatHistory) - 1
+	chat.chatState[last] = chat.getMessageBubble(chat.ChatHistory[last], false, fmt.Sprintf("%d", last))
+	chat.updateViewport()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	chat.toolCtx = ctx
+	chat.cancelExec = cancel
+	chat.executing = true
+	chat.toolQueue = slices.Clone(chat.ChatHistory[last].ToolCalls)
+
+	resetChatCmd := chat.resetPrompt(
+		mutedColor,
+		contrastColor,
+		"Disabled while the tools are running...",
+		DisabledHighlightStyle,
+		false,
+	)
+
+	return tea.Batch(append(resetChatCmd, chat.nextToolCall())...)
+}
+
+// Runs the next queued tool call (once approved if the tool needs it), the
+// model is asked to reply once the queue is empty
+func (chat *Chat) nextToolCall() tea.Cmd {
+	if len(chat.toolQueue) == 0 {
+		chat.executing = false
+		chat.cancelExecution()
+		return chat.resendLastMessage()
+	}
+
+	call := chat.toolQueue[0]
+	chat.toolQueue = chat.toolQueue[1:]
+
+	tool, ok := tools.Get(call.Name)
+	if !ok || !slices.Contains(chat.availableTools(), call.Name) {
+		return func() tea.Msg {
+			return ToolResultMsg{Call: call, Err: fmt.Errorf("the tool %q is not available", call.Name)}
+		}
+	}
+
+	if tool.NeedsApproval {
+		chat.pendingTool = &call
+		chat.approval = newApprovalPreview(toolArgumentsView(call))
+		return nil
+	}
+
+	return chat.runTool(call)
+}
+
+// Runs the tool in the background
+func (chat *Chat) runTool(call ToolCall) tea.Cmd {
+	ctx := chat.toolCtx
+
+	return func() tea.Msg {
+		output, err := tools.Call(ctx, call.Name, call.args())
+		return ToolResultMsg{Call: call, Output: output, Err: err}
+	}
+}
+
+// Adds the output of the tool to the chat as a (collapsed) tool message and
+// runs the next call
+func (chat *Chat) finishToolCall(msg ToolResultMsg) tea.Cmd {
+	// the calls were stopped
+	if !chat.executing {
+		return nil
+	}
+	if chat.toolCtx.Err() != nil {
+		return chat.stopToolCalls("The tool calls were stopped, their output was not sent")
+	}
+
+	output := msg.Output
+	switch {
+	case msg.Err != nil:
+		output = "Error: " + msg.Err.Error()
+	case strings.TrimSpace(output) == "":
+		output = "(no output)"
+	}
+
+	chat.sendMessage(output, roles.TOOL)
+
+	last := len(chat.ChatHistory) - 1
+	node := chat.ChatHistory[last]
+	node.ToolCalls = []ToolCall{msg.Call}
+	node.Collapsed = true
+	chat.chatState[last] = chat.getMessageBubble(node, false, fmt.Sprintf("%d", last))
+	chat.updateViewport()
+
+	return chat.nextToolCall()
+}
+
+// Stops the running tool and drops the queued calls
+func (chat *Chat) stopToolCalls(notification string) tea.Cmd {
+	chat.cancelExecution()
+	chat.executing = false
+	chat.toolQueue = nil
+	chat.pendingTool = nil
+	chat.approval = nil
+
+	return tea.Batch(chat.focusPrompt(), chat.notify(notification))
+}
+
+// Handles the key presses while the tool approval overlay is visible
+func (chat *Chat) handleToolApprovalKeys(msg tea.KeyMsg) tea.Cmd {
+	call := *chat.pendingTool
+
+	switch {
+	case key.Matches(msg, Keys.Confirm):
+		// the tool can't be run before all of its arguments were shown
+		if !chat.approval.seen {
+			return chat.notify("Scroll to the end of the arguments before running the tool")
+		}
+		chat.pendingTool = nil
+		chat.approval = nil
+		return chat.runTool(call)
+	case key.Matches(msg, Keys.Deny, Keys.Close):
+		chat.pendingTool = nil
+		chat.approval = nil
+		return func() tea.Msg {
+			return ToolResultMsg{Call: call, Err: fmt.Errorf("the user denied running the tool")}
+		}
+	case key.Matches(msg, Keys.StopResponse):
+		return chat.stopToolCalls("The tool calls were stopped, their output was not sent")
+	default:
+		chat.approval.update(msg)
+	}
+
+	return nil
+}
+
+// Renders the lines listing the tool calls of a response
+func toolCallsView(calls []ToolCall) string {
+	lines := make([]string, len(calls))
+	for i, call := range calls {
+		lines[i] = fmt.Sprintf("_Calling_ `%s`", call.String())
+	}
+
+	return strings.Join(lines, "\n\n")
+}
+
+// Formats the output of a tool as the body of its message
+func toolOutputView(call ToolCall, output string) string {
+	fence := "```"
+	for strings.Contains(output, fence) {
+		fence += "`"
+	}
+
+	return fmt.Sprintf("`%s`\n\n%s\n%s\n%s", call.String(), fence, strings.TrimRight(output, "\n"), fence)
+}
+
+// Renders the arguments of the call shown for approval, they are shown as they
+// are (e.g. the lines of a shell command) below their names
+func toolArgumentsView(call ToolCall) string {
+	lines := []string{}
+	args := call.args()
+	for _, name := range slices.Sorted(maps.Keys(args)) {
+		value, ok := args[name].(string)
+		if !ok {
+			encoded, _ := json.Marshal(args[name])
+			value = string(encoded)
+		}
+
+		lines = append(lines, HighlightForegroundStyle.Render(name), strings.TrimRight(value, "\n"))
+	}
+
+	return strings.Join(lines, "\n")
+}
+
+// Renders the tool approval overlay on top of the provided content
+func (chat *Chat) toolOverlayView(content string) string {
+	call := chat.pendingTool
+	width := max(40, 6*chat.width/10)
+
+	// the padding of the overlay and the border of the preview
+	chat.approval.resize(width - 6)
+
+	run := "run"
+	if !chat.approval.seen {
+		run = "run (scroll to the end first)"
+	}
+
+	overlay := addToBorder(
+		layoutStyle.
+			Border(lipgloss.RoundedBorder(), false, true, true).
+			Width(width).
+			Padding(1, 2).
+			BorderForeground(dangerColor).
+			Render(
+				lipgloss.JoinVertical(
+					lipgloss.Left,
+					fmt.Sprintf("The model wants to run the %s tool:", call.Name),
+					"",
+					chat.approval.view(),
+					"",
+					lipgloss.NewStyle().Foreground(mutedColor).Render(
+						"The output is sent to the model, denying the call tells the model it was denied",
+					),
+					"",
+					keyOptionsView(
+						keyOption{Keys.Confirm, run},
+						keyOption{Keys.Deny, "deny"},
+						keyOption{Keys.StopResponse, "stop the tool calls"},
+					),
+				),
+			),
+		ErrorStyle.Render(" Run Tool "),
+		dangerColor,
+		"",
+	)
+
+	return utils.PlaceOverlay(
+		(chat.width-lipgloss.Width(overlay))/2,
+		(chat.height-lipgloss.Height(overlay))/2,
+		overlay,
+		content,
+	)
+}
@@ -0,0 +1,248 @@
+package chat
+
+import (
+	"time"
+
+	"github.com/charmbracelet/bubbles/key"
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/gaurav-gosain/gollama/internal/config"
+)
+
+// the time to wait for the second key of a sequence (e.g. gg), like vim's
+// timeoutlen
+const vimSequenceTimeout = time.Second
+
+// The keys of the normal mode, they are fixed like vim's
+type NormalKeyMap struct {
+	ScrollDown      key.Binding
+	ScrollUp        key.Binding
+	Top             key.Binding
+	Bottom          key.Binding
+	PreviousMessage key.Binding
+	NextMessage     key.Binding
+	Yank            key.Binding
+	YankCode        key.Binding
+	Search          key.Binding
+	Insert          key.Binding
+}
+
+var NormalKeys = NormalKeyMap{
+	ScrollDown: key.NewBinding(
+		key.WithKeys("j", "down"),
+		key.WithHelp("j/↓", "Scroll down"),
+	),
+	ScrollUp: key.NewBinding(
+		key.WithKeys("k", "up"),
+		key.WithHelp("k/↑", "Scroll up"),
+	),
+	Top: key.NewBinding(
+		key.WithKeys("g"),
+		key.WithHelp("gg", "Go to the first message"),
+	),
+	Bottom: key.NewBinding(
+		key.WithKeys("G"),
+		key.WithHelp("G", "Go to the last message"),
+	),
+	PreviousMessage: key.NewBinding(
+		key.WithKeys("{"),
+		key.WithHelp("{", "Previous message"),
+	),
+	NextMessage: key.NewBinding(
+		key.WithKeys("}"),
+		key.WithHelp("}", "Next message"),
+	),
+	Yank: key.NewBinding(
+		key.WithKeys("y"),
+		key.WithHelp("y", "Yank highlighted message"),
+	),
+	// only matched after Yank
+	YankCode: key.NewBinding(
+		key.WithKeys("c"),
+		key.WithHelp("yc", "Yank nearest code block"),
+	),
+	Search: key.NewBinding(
+		key.WithKeys("/"),
+		key.WithHelp("/", "Search messages"),
+	),
+	Insert: key.NewBinding(
+		key.WithKeys("i"),
+		key.WithHelp("i", "Back to the prompt"),
+	),
+}
+
+// Returns the normal mode keys shown in the help menu
+func (k NormalKeyMap) help() []key.Binding {
+	return []key.Binding{
+		k.ScrollDown,
+		k.ScrollUp,
+		k.Top,
+		k.Bottom,
+		k.PreviousMessage,
+		k.NextMessage,
+		k.Yank,
+		k.YankCode,
+		k.Search,
+		k.Insert,
+	}
+}
+
+// vimTimeoutMsg is sent once the second key of a sequence is no longer
+// awaited, sequence identifies the sequence it was started for
+type vimTimeoutMsg struct {
+	sequence int
+}
+
+// Returns true if the key leaves the prompt for the normal mode
+func (chat *Chat) entersNormalMode(msg tea.KeyMsg) bool {
+	return config.Current.VimMode && key.Matches(msg, Keys.NormalMode)
+}
+
+// Leaves the prompt for the normal mode, the draft is kept
+func (chat *Chat) enterNormalMode() tea.Cmd {
+	chat.normalMode = true
+	chat.pendingVimKey = ""
+
+	if chat.streaming || chat.executing {
+		return nil
+	}
+	return chat.focusPrompt()
+}
+
+// Goes back to the prompt
+func (chat *Chat) enterInsertMode() tea.Cmd {
+	chat.normalMode = false
+	chat.pendingVimKey = ""
+
+	if chat.streaming || chat.executing {
+		return nil
+	}
+	return chat.focusPrompt()
+}
+
+// Handles the key presses of the vim mode, returns false if the key should be
+// handled by the chat instead (it never reaches the prompt in normal mode)
+func (chat *Chat) handleVimKeys(msg tea.KeyMsg) (bool, tea.Cmd) {
+	if !chat.normalMode {
+		if chat.entersNormalMode(msg) {
+			return true, chat.enterNormalMode()
+		}
+		return false, nil
+	}
+
+	// the second key of a sequence
+	if pending := chat.pendingVimKey; pending != "" {
+		chat.pendingVimKey = ""
+
+		switch {
+		case pending == "g" && key.Matches(msg, NormalKeys.Top):
+			chat.highlightMessage(0)
+			return true, nil
+		case pending == "y" && key.Matches(msg, NormalKeys.YankCode):
+			return true, chat.yankNearestCodeBlock()
+		case pending == "y" && key.Matches(msg, NormalKeys.Yank):
+			return true, chat.yankHighlighted()
+		case pending == "y":
+			// the message is yanked, the key is only handled if it is one of
+			// the normal mode's
+			_, cmd := chat.handleVimKeys(msg)
+			return true, tea.Batch(chat.yankHighlighted(), cmd)
+		}
+	}
+
+	switch {
+	case chat.entersNormalMode(msg):
+		// like in vim, esc cancels the pending sequence
+	case key.Matches(msg, NormalKeys.ScrollDown):
+		chat.viewport.LineDown(1)
+	case key.Matches(msg, NormalKeys.ScrollUp):
+		chat.viewport.LineUp(1)
+	case key.Matches(msg, NormalKeys.Top, NormalKeys.Yank):
+		return true, chat.awaitVimKey(msg.String())
+	case key.Matches(msg, NormalKeys.Bottom):
+		chat.highlightMessage(len(chat.ChatHistory) - 1)
+		chat.viewport.GotoBottom()
+	case key.Matches(msg, NormalKeys.PreviousMessage):
+		chat.highlightMessage(chat.highlightedChatIndex - 1)
+	case key.Matches(msg, NormalKeys.NextMessage):
+		chat.highlightMessage(chat.highlightedChatIndex + 1)
+	case key.Matches(msg, NormalKeys.Search):
+		if chat.streaming {
+			return true, nil
+		}
+		return true, chat.openSearch()
+	case key.Matches(msg, NormalKeys.Insert):
+		return true, chat.enterInsertMode()
+	case msg.Type == tea.KeyRunes && !msg.Alt:
+		// the other characters are ignored instead of being typed
+	default:
+		return false, nil
+	}
+
+	return true, nil
+}
+
+// Waits for the second key of the sequence started with the provided key
+func (chat *Chat) awaitVimKey(first string) tea.Cmd {
+	chat.pendingVimKey = first
+	chat.vimSequence++
+
+	sequence := chat.vimSequence
+	return tea.Tick(vimSequenceTimeout, func(_ time.Time) tea.Msg {
+		return vimTimeoutMsg{sequence: sequence}
+	})
+}
+
+// Completes the pending sequence once its second key is no longer awaited, y
+// yanks the highlighted message on its own
+func (chat *Chat) finishVimSequence(msg vimTimeoutMsg) tea.Cmd {
+	if msg.sequence != chat.vimSequence || chat.pendingVimKey == "" {
+		return nil
+	}
+
+	pending := chat.pendingVimKey
+	chat.pendingVimKey = ""
+
+	if pending == "y" {
+		return chat.yankHighlighted()
+	}
+	return nil
+}
+
+// Copies the highlighted message to the clipboard
+func (chat *Chat) yankHighlighted() tea.Cmd {
+	if len(chat.ChatHistory) == 0 {
+		return nil
+	}
+
+	return chat.CopyToClipboard(chat.ChatHistory[chat.highlightedChatIndex].Message, CopyHighlighted)
+}
+
+// Copies the first code block of the highlighted message to the clipboard, or
+// of the closest message with code blocks (the earlier one on a tie)
+func (chat *Chat) yankNearestCodeBlock() tea.Cmd {
+	for distance := range len(chat.ChatHistory) {
+		for _, index := range []int{chat.highlightedChatIndex - distance, chat.highlightedChatIndex + distance} {
+			if index < 0 || index >= len(chat.ChatHistory) {
+				continue
+			}
+
+			if blocks := extractCodeBlocks(chat.ChatHistory[index].Message); len(blocks) > 0 {
+				return chat.CopyToClipboard(blocks[0].Code, CopyCodeBlock)
+			}
+		}
+	}
+
+	return chat.notify("No message has code blocks")
+}
+
+// Renders the help line of the normal mode
+func (chat *Chat) normalModeHelpView() string {
+	view := HighlightStyle.Render(" NORMAL ") + " " + helpStyle(
+		"j/k scroll • gg/G first/last • {/} messages • y yank • yc yank code • / search • i insert",
+	)
+	if chat.pendingVimKey != "" {
+		view += " " + HighlightForegroundStyle.Render(chat.pendingVimKey)
+	}
+
+	return view
+}
@@ -21,6 +21,7 @@ const (
 	ExitReasonSelect     ExitReason = "select"
 	ExitReasonNewChat    ExitReason = "new_chat"
 	ExitReasonDeleteChat ExitReason = "delete_chat"
+	ExitReasonEditChat   ExitReason = "edit_chat"
 )
 
 // basic bubbletea list model for the chat picker
@@ -38,39 +39,46 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 	switch msg := msg.(type) {
 	case tea.KeyMsg:
 		if m.list.FilterState() != list.Filtering {
-			switch msg.String() {
-			case "esc":
-				if m.list.FilterState() != list.FilterApplied {
+			switch {
+			case key.Matches(msg, Keys.Quit):
+				// the applied filter is cleared first
+				if m.list.FilterState() != list.FilterApplied || !key.Matches(msg, m.list.KeyMap.ClearFilter) {
 					m.exitReason = ExitReasonCancel
 					return m, tea.Quit
 				}
-			case "ctrl+c", "q":
-				m.exitReason = ExitReasonCancel
-				return m, tea.Quit
-			case "ctrl+n":
+			case key.Matches(msg, Keys.NewChat):
 				m.exitReason = ExitReasonNewChat
 				return m, tea.Quit
-			case "enter":
+			case key.Matches(msg, Keys.Select):
 				i, ok := m.list.SelectedItem().(client.Chat)
 				if ok {
 					m.selectedChat = i
 					m.exitReason = ExitReasonSelect
 				}
 				return m, tea.Quit
-			case "d":
+			case key.Matches(msg, Keys.Delete):
 				i, ok := m.list.SelectedItem().(client.Chat)
 				if ok {
 					m.exitReason = ExitReasonDeleteChat
 					m.selectedChat = i
 					return m, tea.Quit
 				}
+			case key.Matches(msg, Keys.Edit):
+				i, ok := m.list.SelectedItem().(client.Chat)
+				if ok {
+					m.exitReason = ExitReasonEditChat
+					m.selectedChat = i
+					return m, tea.Quit
+				}
 			}
-		} else {
-			switch msg.String() {
-			case "ctrl+c":
+		} else if (msg.Type != tea.KeyRunes || msg.Alt) &&
+			!key.Matches(msg, m.list.KeyMap.CancelWhileFiltering, m.list.KeyMap.AcceptWhileFiltering) {
+			// the characters are typed in the filter
+			switch {
+			case key.Matches(msg, Keys.Quit):
 				m.exitReason = ExitReasonCancel
 				return m, tea.Quit
-			case "ctrl+n":
+			case key.Matches(msg, Keys.NewChat):
 				m.exitReason = ExitReasonNewChat
 				return m, tea.Quit
 			}
@@ -99,15 +107,14 @@ func NewChatPicker(items []list.Item) (client.Chat, ExitReason, error) {
 	)}
 	m.list.Title = "Pick a chat"
 
+	// the list shows the quit key in its help, the picker handles it
+	m.list.KeyMap.Quit = Keys.Quit
+	m.list.KeyMap.ForceQuit.SetEnabled(false)
+
 	additionalKeys := []key.Binding{
-		key.NewBinding(
-			key.WithKeys("ctrl+n"),
-			key.WithHelp("New Chat", "ctrl+n"),
-		),
-		key.NewBinding(
-			key.WithKeys("d"),
-			key.WithHelp("Delete Chat", "d"),
-		),
+		Keys.NewChat,
+		Keys.Delete,
+		Keys.Edit,
 	}
 
 	m.list.AdditionalShortHelpKeys = func() []key.Binding {
@@ -0,0 +1,68 @@
+package chatpicker
+
+import (
+	"github.com/charmbracelet/bubbles/key"
+	"github.com/gaurav-gosain/gollama/internal/config"
+)
+
+// The keybindings of the chat picker, the keys can be overridden in the
+// "keys.picker" section of the config file (see ApplyKeys)
+type KeyMap struct {
+	Select  key.Binding
+	NewChat key.Binding
+	Delete  key.Binding
+	Edit    key.Binding
+	Quit    key.Binding
+}
+
+var Keys = KeyMap{
+	Select: key.NewBinding(
+		key.WithKeys("enter"),
+		key.WithHelp("enter", "Select Chat"),
+	),
+	NewChat: key.NewBinding(
+		key.WithKeys("ctrl+n"),
+		key.WithHelp("ctrl+n", "New Chat"),
+	),
+	Delete: key.NewBinding(
+		key.WithKeys("d"),
+		key.WithHelp("d", "Delete Chat"),
+	),
+	Edit: key.NewBinding(
+		key.WithKeys("e"),
+		key.WithHelp("e", "Edit Chat"),
+	),
+	Quit: key.NewBinding(
+		key.WithKeys("q", "esc", "ctrl+c"),
+		key.WithHelp("q/esc/ctrl+c", "Quit"),
+	),
+}
+
+// Returns the bindings by the names used in the config file
+func (k *KeyMap) bindings() map[string]*key.Binding {
+	return map[string]*key.Binding{
+		"select":   &k.Select,
+		"new_chat": &k.NewChat,
+		"delete":   &k.Delete,
+		"edit":     &k.Edit,
+		"quit":     &k.Quit,
+	}
+}
+
+// ApplyKeys overrides the keys of the chat picker with the ones of the config
+// file, the keys are left unchanged if an override is unknown or conflicts
+// with another binding
+func ApplyKeys(overrides map[string][]string) error {
+	keys := Keys
+	bindings := keys.bindings()
+
+	if err := config.ApplyKeys("picker", bindings, overrides); err != nil {
+		return err
+	}
+	if err := config.KeyConflicts("picker", bindings); err != nil {
+		return err
+	}
+
+	Keys = keys
+	return nil
+}
@@ -22,13 +22,30 @@ type Gollama struct {
 }
 
 type Chat struct {
-	UpdatedAt     time.Time `db:"updated_at"`
-	ID            string    `db:"id"`
-	ChatTitle     string    `db:"title"`
-	SystemMessage string    `db:"system_message"`
-	ModelName     string    `db:"model_name"`
-	IsAnonymous   bool      `db:"is_anonymous"`
-	IsMultiModal  bool      `db:"is_multi_modal"`
+	UpdatedAt       time.Time `db:"updated_at"`
+	ID              string    `db:"id"`
+	ChatTitle       string    `db:"title"`
+	SystemMessage   string    `db:"system_message"`
+	ModelName       string    `db:"model_name"`
+	ContextStrategy string    `db:"context_strategy"`
+	NumCtx          int       `db:"num_ctx"`
+	KeepTurns       int       `db:"keep_turns"`
+	CompactAt       int       `db:"compact_at"`
+	Tools           string    `db:"tools"`       // the names of the enabled tools, separated by commas
+	MCPServers      string    `db:"mcp_servers"` // the names of the enabled MCP servers, separated by commas
+	AllowExec       bool      `db:"allow_exec"`
+	IsAnonymous     bool      `db:"is_anonymous"`
+	IsMultiModal    bool      `db:"is_multi_modal"`
+}
+
+// A command run from a chat (see LogExecution)
+type Execution struct {
+	ChatID   string
+	Command  string
+	Stdout   string
+	Stderr   string
+	ExitCode int
+	Duration time.Duration
 }
 
 // Implements the bubbletea.ListItem interface
@@ -72,6 +89,23 @@ func (g *Gollama) Migrate() error {
 		return fmt.Errorf("could not migrate db: %w", err)
 	}
 
+	// columns added after the chats table was first released
+	columns := []struct{ name, definition string }{
+		{"num_ctx", "integer NOT NULL DEFAULT 0"},
+		{"context_strategy", "string NOT NULL DEFAULT 'none'"},
+		{"keep_turns", "integer NOT NULL DEFAULT 8"},
+		{"compact_at", "integer NOT NULL DEFAULT 0"},
+		{"allow_exec", "boolean NOT NULL DEFAULT 0"},
+		{"tools", "string NOT NULL DEFAULT ''"},
+		{"mcp_servers", "string NOT NULL DEFAULT ''"},
+	}
+
+	for _, column := range columns {
+		if err := g.addColumn("chats", column.name, column.definition); err != nil {
+			return fmt.Errorf("could not migrate db: %w", err)
+		}
+	}
+
 	if _, err := g.DB.Exec(`
 		CREATE INDEX IF NOT EXISTS idx_chat_id ON chats (id)
 	`); err != nil {
@@ -83,9 +117,52 @@ func (g *Gollama) Migrate() error {
 		return fmt.Errorf("could not migrate db: %w", err)
 	}
 
+	// log of the code blocks run from the chats
+	if _, err := g.DB.Exec(`
+		CREATE TABLE
+		  IF NOT EXISTS executions (
+		    id integer NOT NULL PRIMARY KEY AUTOINCREMENT,
+		    chat_id string NOT NULL,
+		    command string NOT NULL,
+		    exit_code integer NOT NULL,
+		    stdout string NOT NULL,
+		    stderr string NOT NULL,
+		    duration_ms integer NOT NULL,
+		    created_at datetime NOT NULL DEFAULT (strftime ('%Y-%m-%d %H:%M:%f', 'now'))
+		  )
+	`); err != nil {
+		return fmt.Errorf("could not migrate db: %w", err)
+	}
+	if _, err := g.DB.Exec(`
+		CREATE INDEX IF NOT EXISTS idx_execution_chat_id ON executions (chat_id)
+	`); err != nil {
+		return fmt.Errorf("could not migrate db: %w", err)
+	}
+
 	return nil
 }
 
+// adds a column to the table if it doesn't exist yet (sqlite has no
+// ADD COLUMN IF NOT EXISTS)
+func (g *Gollama) addColumn(table, name, definition string) error {
+	count := 0
+	if err := g.DB.Get(
+		&count,
+		"SELECT COUNT(*) FROM pragma_table_info(?) WHERE name = ?",
+		table,
+		name,
+	); err != nil {
+		return handleSqliteErr(err)
+	}
+
+	if count > 0 {
+		return nil
+	}
+
+	_, err := g.DB.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", table, name, definition))
+	return handleSqliteErr(err)
+}
+
 // Initializes the sqlite database
 func (g *Gollama) InitDB() error {
 	db, err := initDatabase()
@@ -129,8 +206,9 @@ func (g *Gollama) ListChats() ([]Chat, error) {
 func (g *Gollama) CreateChat(chat Chat) error {
 	_, err := g.DB.Exec(
 		`
-        INSERT INTO chats (id, title, system_message, is_anonymous, model_name, is_multi_modal)
-        VALUES (?, ?, ?, ?, ?, ?)
+        INSERT INTO chats (id, title, system_message, is_anonymous, model_name, is_multi_modal,
+                           num_ctx, context_strategy, keep_turns, compact_at, allow_exec, tools, mcp_servers)
+        VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
     `,
 		chat.ID,
 		chat.ChatTitle,
@@ -138,6 +216,13 @@ func (g *Gollama) CreateChat(chat Chat) error {
 		chat.IsAnonymous,
 		chat.ModelName,
 		chat.IsMultiModal,
+		chat.NumCtx,
+		chat.ContextStrategy,
+		chat.KeepTurns,
+		chat.CompactAt,
+		chat.AllowExec,
+		chat.Tools,
+		chat.MCPServers,
 	)
 	if err != nil {
 		return fmt.Errorf("could not create chat: %w", err)
@@ -145,6 +230,38 @@ func (g *Gollama) CreateChat(chat Chat) error {
 	return nil
 }
 
+// updates the settings (title, system message, model, context window) of the
+// chat with the given Chat struct's ID, also bumps the chat's updated_at
+// timestamp
+func (g *Gollama) UpdateChat(chat Chat) error {
+	_, err := g.DB.Exec(
+		`
+        UPDATE chats
+        SET title = ?, system_message = ?, model_name = ?, is_multi_modal = ?,
+            num_ctx = ?, context_strategy = ?, keep_turns = ?, compact_at = ?,
+            allow_exec = ?, tools = ?, mcp_servers = ?,
+            updated_at = strftime ('%Y-%m-%d %H:%M:%f', 'now')
+        WHERE id = ?
+    `,
+		chat.ChatTitle,
+		chat.SystemMessage,
+		chat.ModelName,
+		chat.IsMultiModal,
+		chat.NumCtx,
+		chat.ContextStrategy,
+		chat.KeepTurns,
+		chat.CompactAt,
+		chat.AllowExec,
+		chat.Tools,
+		chat.MCPServers,
+		chat.ID,
+	)
+	if err != nil {
+		return fmt.Errorf("could not update chat: %w", err)
+	}
+	return nil
+}
+
 // deletes a chat from the sqlite database with the given ID
 func (g *Gollama) DeleteChat(id string) error {
 	_, err := g.DB.Exec(
@@ -156,5 +273,29 @@ func (g *Gollama) DeleteChat(id string) error {
 	if err != nil {
 		return fmt.Errorf("could not delete chat: %w", err)
 	}
+
+	if _, err := g.DB.Exec(`DELETE FROM executions WHERE chat_id = ?`, id); err != nil {
+		return fmt.Errorf("could not delete chat executions: %w", err)
+	}
+	return nil
+}
+
+// logs a command that was run from the chat with the given ID
+func (g *Gollama) LogExecution(execution Execution) error {
+	_, err := g.DB.Exec(
+		`
+        INSERT INTO executions (chat_id, command, exit_code, stdout, stderr, duration_ms)
+        VALUES (?, ?, ?, ?, ?, ?)
+    `,
+		execution.ChatID,
+		execution.Command,
+		execution.ExitCode,
+		execution.Stdout,
+		execution.Stderr,
+		execution.Duration.Milliseconds(),
+	)
+	if err != nil {
+		return fmt.Errorf("could not log execution: %w", err)
+	}
 	return nil
 }
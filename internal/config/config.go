@@ -0,0 +1,92 @@
+package config
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/adrg/xdg"
+)
+
+// the name of the config file in the config directory
+const configFile = "config.json"
+
+// The user configuration of gollama, stored as JSON in the config directory
+type Config struct {
+	// the name of the theme, AutoTheme picks the light or dark theme based on
+	// the background of the terminal
+	Theme string `json:"theme"`
+	// the keybinding overrides, the default keys are used for the bindings
+	// that are not set
+	Keys KeysConfig `json:"keys"`
+	// esc leaves the prompt for a vim-like normal mode instead of exiting the
+	// chat
+	VimMode bool `json:"vim_mode"`
+	// the Model Context Protocol servers by name, their tools are offered to
+	// the models
+	MCPServers map[string]MCPServer `json:"mcp_servers,omitempty"`
+}
+
+// A Model Context Protocol server launched by gollama, it is spoken to over
+// its stdin and stdout
+type MCPServer struct {
+	Command string            `json:"command"`
+	Args    []string          `json:"args,omitempty"`
+	Env     map[string]string `json:"env,omitempty"` // added to gollama's environment
+	// the server is started for every chat, the others are enabled in the
+	// settings of each chat
+	Global bool `json:"global,omitempty"`
+	// every tool call has to be approved by the user
+	Approve bool `json:"approve,omitempty"`
+}
+
+// Current is the configuration loaded by Load
+var Current = Default()
+
+// Returns the default configuration
+func Default() Config {
+	return Config{
+		Theme: AutoTheme,
+	}
+}
+
+// Returns the directory of the config file and the user themes
+func Dir() string {
+	return filepath.Join(xdg.ConfigHome, "gollama")
+}
+
+// Loads the config file into Current, the defaults are used if it doesn't
+// exist
+func Load() error {
+	data, err := os.ReadFile(filepath.Join(Dir(), configFile))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	cfg := Default()
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		return fmt.Errorf("could not parse %s: %w", configFile, err)
+	}
+
+	Current = cfg
+	return nil
+}
+
+// Writes Current to the config file
+func Save() error {
+	if err := os.MkdirAll(Dir(), 0o755); err != nil { //nolint:mnd
+		return err
+	}
+
+	data, err := json.MarshalIndent(Current, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(filepath.Join(Dir(), configFile), append(data, '\n'), 0o644) //nolint:mnd
+}
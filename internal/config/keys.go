@@ -0,0 +1,87 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+
+	"github.com/charmbracelet/bubbles/key"
+)
+
+// The keybinding overrides of each screen, the binding names are mapped to
+// their keys (an empty list disables the binding)
+type KeysConfig struct {
+	Chat   map[string][]string `json:"chat,omitempty"`
+	Picker map[string][]string `json:"picker,omitempty"`
+}
+
+// the symbols shown in the help instead of the names of the arrow keys
+var keySymbols = strings.NewReplacer(
+	"up", "↑",
+	"down", "↓",
+	"left", "←",
+	"right", "→",
+)
+
+// Returns the keys as they are shown in the help, e.g. "alt+enter/ctrl+j"
+func HelpKey(keys []string) string {
+	parts := make([]string, len(keys))
+	for i, k := range keys {
+		mods := strings.Split(k, "+")
+		mods[len(mods)-1] = keySymbols.Replace(mods[len(mods)-1])
+		parts[i] = strings.Join(mods, "+")
+	}
+
+	return strings.Join(parts, "/")
+}
+
+// Sets the keys of the named bindings to the overrides and updates their help,
+// the scope is the name of the screen used in the errors
+func ApplyKeys(scope string, bindings map[string]*key.Binding, overrides map[string][]string) error {
+	var errs []error
+
+	for _, name := range slices.Sorted(maps.Keys(overrides)) {
+		binding, ok := bindings[name]
+		if !ok {
+			errs = append(errs, fmt.Errorf("unknown %s keybinding %q", scope, name))
+			continue
+		}
+
+		keys := overrides[name]
+		binding.SetKeys(keys...)
+		binding.SetHelp(HelpKey(keys), binding.Help().Desc)
+		binding.SetEnabled(len(keys) > 0)
+	}
+
+	return errors.Join(errs...)
+}
+
+// Returns an error for every key bound to more than one of the bindings, only
+// the first matching binding would ever be triggered
+func KeyConflicts(scope string, bindings map[string]*key.Binding) error {
+	bound := map[string][]string{}
+	for _, name := range slices.Sorted(maps.Keys(bindings)) {
+		if !bindings[name].Enabled() {
+			continue
+		}
+		for _, k := range bindings[name].Keys() {
+			bound[k] = append(bound[k], name)
+		}
+	}
+
+	var errs []error
+	for _, k := range slices.Sorted(maps.Keys(bound)) {
+		if names := bound[k]; len(names) > 1 {
+			errs = append(errs, fmt.Errorf(
+				"%q is bound to several %s keybindings: %s",
+				k,
+				scope,
+				strings.Join(names, ", "),
+			))
+		}
+	}
+
+	return errors.Join(errs...)
+}
@@ -0,0 +1,191 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/charmbracelet/glamour"
+	"github.com/charmbracelet/glamour/ansi"
+	"github.com/charmbracelet/glamour/styles"
+	"github.com/muesli/termenv"
+)
+
+const (
+	// picks the light or dark theme based on the background of the terminal
+	AutoTheme  = "auto"
+	DarkTheme  = "dark"
+	LightTheme = "light"
+)
+
+// A color theme of the chat, the colors are hex codes (or ANSI color numbers)
+type Theme struct {
+	Name       string `json:"-"`
+	Primary    string `json:"primary"`    // titles, borders and the prompt
+	OnPrimary  string `json:"on_primary"` // the text on the primary color (terminal default if empty)
+	Active     string `json:"active"`     // the highlighted message
+	Notice     string `json:"notice"`     // notifications
+	Foreground string `json:"foreground"` // the text of the messages
+	Muted      string `json:"muted"`      // collapsed messages, disabled prompt and details
+	Contrast   string `json:"contrast"`   // the text on the other colors
+	Danger     string `json:"danger"`     // errors and confirmations of risky actions
+	Help       string `json:"help"`       // the help line
+	HelpKey    string `json:"help_key"`   // the keys in the help menu
+	// the glamour style of the messages: the name of a standard style
+	// (e.g. dracula), the path of a style JSON file (relative to the themes
+	// directory) or the style itself
+	Glamour json.RawMessage `json:"glamour"`
+}
+
+// the built-in themes, user themes with the same name replace them
+var builtinThemes = map[string]Theme{
+	DarkTheme: {
+		Primary:    "#8839ef",
+		Active:     "#00baba",
+		Notice:     "#ff9900",
+		Foreground: "#FFFDF5",
+		Muted:      "#aaaaaa",
+		Contrast:   "#000000",
+		Danger:     "#FF5F87",
+		Help:       "241",
+		HelpKey:    "#73F59F",
+		Glamour:    json.RawMessage(`"dracula"`),
+	},
+	LightTheme: {
+		Primary:    "#8839ef",
+		OnPrimary:  "#ffffff",
+		Active:     "#007a7a",
+		Notice:     "#d75f00",
+		Foreground: "#303030",
+		Muted:      "#767676",
+		Contrast:   "#ffffff",
+		Danger:     "#d7005f",
+		Help:       "245",
+		HelpKey:    "#43BF6D",
+		Glamour:    json.RawMessage(`"light"`),
+	},
+}
+
+// Returns the built-in dark theme, used until the user's theme is loaded
+func DefaultTheme() Theme {
+	theme := builtinThemes[DarkTheme]
+	theme.Name = DarkTheme
+	return theme
+}
+
+var (
+	darkBackground     bool
+	detectedBackground sync.Once
+)
+
+// Returns true if the terminal has a dark background, the terminal is only
+// queried once (it can't be queried while the TUI is running)
+func HasDarkBackground() bool {
+	detectedBackground.Do(func() {
+		darkBackground = termenv.HasDarkBackground()
+	})
+
+	return darkBackground
+}
+
+// Returns the directory of the user themes
+func ThemesDir() string {
+	return filepath.Join(Dir(), "themes")
+}
+
+// Returns the names of the built-in and user themes
+func ThemeNames() []string {
+	names := []string{AutoTheme}
+	for name := range builtinThemes {
+		names = append(names, name)
+	}
+
+	entries, _ := os.ReadDir(ThemesDir())
+	for _, entry := range entries {
+		if name, ok := strings.CutSuffix(entry.Name(), ".json"); ok && !entry.IsDir() {
+			names = append(names, name)
+		}
+	}
+
+	slices.Sort(names)
+	return slices.Compact(names)
+}
+
+// Loads the theme with the provided name, a user theme (themes/<name>.json)
+// only has to set the colors it changes
+func LoadTheme(name string) (Theme, error) {
+	// the missing colors of user themes are taken from the theme matching the
+	// background of the terminal
+	fallback := LightTheme
+	if HasDarkBackground() {
+		fallback = DarkTheme
+	}
+
+	if name == "" || name == AutoTheme {
+		name = fallback
+	}
+
+	theme, builtin := builtinThemes[name]
+	if !builtin {
+		theme = builtinThemes[fallback]
+	}
+
+	data, err := os.ReadFile(filepath.Join(ThemesDir(), name+".json"))
+	switch {
+	case err == nil:
+		if err := json.Unmarshal(data, &theme); err != nil {
+			return Theme{}, fmt.Errorf("could not parse the %s theme: %w", name, err)
+		}
+	case !builtin:
+		return Theme{}, fmt.Errorf("unknown theme %s", name)
+	}
+
+	theme.Name = name
+
+	if _, err := theme.glamourStyle(); err != nil {
+		return Theme{}, fmt.Errorf("invalid glamour style of the %s theme: %w", name, err)
+	}
+
+	return theme, nil
+}
+
+// Returns the glamour option rendering the messages with the theme's style
+func (theme Theme) GlamourStyle() glamour.TermRendererOption {
+	option, err := theme.glamourStyle()
+	if err != nil {
+		return glamour.WithStandardStyle(styles.DraculaStyle)
+	}
+
+	return option
+}
+
+func (theme Theme) glamourStyle() (glamour.TermRendererOption, error) {
+	data := []byte(theme.Glamour)
+
+	var style string
+	if err := json.Unmarshal(theme.Glamour, &style); err == nil {
+		if _, ok := styles.DefaultStyles[style]; ok {
+			return glamour.WithStandardStyle(style), nil
+		}
+
+		path := style
+		if !filepath.IsAbs(path) {
+			path = filepath.Join(ThemesDir(), path)
+		}
+
+		if data, err = os.ReadFile(path); err != nil {
+			return nil, err
+		}
+	}
+
+	var config ansi.StyleConfig
+	if err := json.Unmarshal(data, &config); err != nil {
+		return nil, err
+	}
+
+	return glamour.WithStyles(config), nil
+}
@@ -0,0 +1,404 @@
+package mcp
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"runtime/debug"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gaurav-gosain/gollama/internal/config"
+)
+
+const (
+	// the version of the protocol requested from the servers
+	protocolVersion = "2024-11-05"
+	// the time a server has to answer the initialize request
+	initializeTimeout = 30 * time.Second
+	// the time a server has to exit once its stdin is closed, it is killed
+	// afterwards
+	stopTimeout = 2 * time.Second
+	// the number of bytes of stderr kept for the errors
+	maxStderr = 4096
+)
+
+// the JSON-RPC error codes sent to the servers
+const (
+	codeMethodNotFound = -32601
+)
+
+// A JSON-RPC request (or notification if it has no ID) sent to a server
+type request struct {
+	JSONRPC string `json:"jsonrpc"`
+	ID      *int64 `json:"id,omitempty"`
+	Method  string `json:"method"`
+	Params  any    `json:"params,omitempty"`
+}
+
+// A JSON-RPC response sent to a server's request
+type response struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id"`
+	Result  any             `json:"result,omitempty"`
+	Error   *RPCError       `json:"error,omitempty"`
+}
+
+// A JSON-RPC message received from a server, a response if it has no method
+type message struct {
+	ID     json.RawMessage `json:"id,omitempty"`
+	Method string          `json:"method,omitempty"`
+	Result json.RawMessage `json:"result,omitempty"`
+	Error  *RPCError       `json:"error,omitempty"`
+}
+
+// RPCError is the error of a JSON-RPC response
+type RPCError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+func (err *RPCError) Error() string {
+	return fmt.Sprintf("%s (code %d)", err.Message, err.Code)
+}
+
+// The name and version the server reported when it was initialized
+type ServerInfo struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+}
+
+// A client connected to an MCP server running as a subprocess, the messages
+// are exchanged as lines of JSON over its stdin and stdout
+type Client struct {
+	Name string
+	Info ServerInfo
+
+	cmd     *exec.Cmd
+	stdin   io.WriteCloser
+	stderr  *tailBuffer
+	writeMu sync.Mutex
+
+	mu      sync.Mutex
+	nextID  int64
+	pending map[int64]chan message
+
+	// closed once the server's stdout is closed (usually when it exits), err
+	// is why
+	done chan struct{}
+	err  error
+}
+
+// Launches the server and initializes the MCP session
+func Start(ctx context.Context, name string, server config.MCPServer) (*Client, error) {
+	if strings.TrimSpace(server.Command) == "" {
+		return nil, errors.New("no command is configured")
+	}
+
+	// the server outlives the context, it is stopped by Close
+	cmd := exec.Command(server.Command, server.Args...) //nolint:gosec
+	cmd.Env = os.Environ()
+	for key, value := range server.Env {
+		cmd.Env = append(cmd.Env, key+"="+value)
+	}
+
+	client := &Client{
+		Name:    name,
+		cmd:     cmd,
+		stderr:  &tailBuffer{},
+		pending: map[int64]chan message{},
+		done:    make(chan struct{}),
+	}
+	// the server's logs would be drawn over the TUI
+	cmd.Stderr = client.stderr
+	// the processes started by the server could keep its stderr open, Wait
+	// would never return once the server exited
+	cmd.WaitDelay = stopTimeout
+
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	client.stdin = stdin
+
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+
+	go client.readLoop(stdout)
+
+	ctx, cancel := context.WithTimeout(ctx, initializeTimeout)
+	defer cancel()
+
+	if err := client.initialize(ctx); err != nil {
+		// the error of a server that exited already ends with its stderr
+		select {
+		case <-client.done:
+		default:
+			err = client.withStderr(err)
+		}
+		client.Close()
+		return nil, fmt.Errorf("could not initialize: %w", err)
+	}
+
+	return client, nil
+}
+
+// Negotiates the protocol version and the capabilities with the server
+func (client *Client) initialize(ctx context.Context) error {
+	var result struct {
+		ProtocolVersion string     `json:"protocolVersion"`
+		ServerInfo      ServerInfo `json:"serverInfo"`
+	}
+
+	err := client.call(ctx, "initialize", map[string]any{
+		"protocolVersion": protocolVersion,
+		"capabilities":    map[string]any{},
+		"clientInfo": map[string]any{
+			"name":    "gollama",
+			"version": clientVersion(),
+		},
+	}, &result)
+	if err != nil {
+		return err
+	}
+
+	client.Info = result.ServerInfo
+
+	return client.notify("notifications/initialized", nil)
+}
+
+// Returns the version of gollama sent to the servers
+func clientVersion() string {
+	if info, ok := debug.ReadBuildInfo(); ok && info.Main.Version != "" {
+		return info.Main.Version
+	}
+	return "dev"
+}
+
+// Sends the request and decodes its result into result (if not nil), the
+// server is told to cancel the request if the context is done first
+func (client *Client) call(ctx context.Context, method string, params, result any) error {
+	client.mu.Lock()
+	client.nextID++
+	id := client.nextID
+	responses := make(chan message, 1)
+	client.pending[id] = responses
+	client.mu.Unlock()
+
+	defer func() {
+		client.mu.Lock()
+		delete(client.pending, id)
+		client.mu.Unlock()
+	}()
+
+	if err := client.write(request{JSONRPC: "2.0", ID: &id, Method: method, Params: params}); err != nil {
+		// the stdin of a server that exited is closed, why it exited is more
+		// useful
+		select {
+		case <-client.done:
+			return client.err
+		case <-time.After(stopTimeout):
+			return err
+		}
+	}
+
+	select {
+	case msg := <-responses:
+		if msg.Error != nil {
+			return msg.Error
+		}
+		if result == nil {
+			return nil
+		}
+		if err := json.Unmarshal(msg.Result, result); err != nil {
+			return fmt.Errorf("invalid %s result: %w", method, err)
+		}
+		return nil
+	case <-client.done:
+		return client.err
+	case <-ctx.Done():
+		_ = client.notify("notifications/cancelled", map[string]any{
+			"requestId": id,
+			"reason":    ctx.Err().Error(),
+		})
+		return ctx.Err()
+	}
+}
+
+// Sends the notification, no response is expected
+func (client *Client) notify(method string, params any) error {
+	return client.write(request{JSONRPC: "2.0", Method: method, Params: params})
+}
+
+// Writes the message to the server's stdin as a line of JSON
+func (client *Client) write(msg any) error {
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+
+	client.writeMu.Lock()
+	defer client.writeMu.Unlock()
+
+	if _, err := client.stdin.Write(append(data, '\n')); err != nil {
+		return fmt.Errorf("could not write to the server: %w", err)
+	}
+
+	return nil
+}
+
+// Reads the messages of the server until its stdout is closed, the responses
+// are delivered to the pending calls
+func (client *Client) readLoop(stdout io.Reader) {
+	reader := bufio.NewReader(stdout)
+
+	for {
+		line, err := reader.ReadBytes('\n')
+		if line = bytes.TrimSpace(line); len(line) > 0 {
+			client.handle(line)
+		}
+
+		if err != nil {
+			waitErr := client.cmd.Wait()
+			switch {
+			case waitErr != nil:
+				client.err = fmt.Errorf("the server exited: %w", waitErr)
+			case errors.Is(err, io.EOF):
+				client.err = errors.New("the server exited")
+			default:
+				client.err = err
+			}
+			client.err = client.withStderr(client.err)
+			close(client.done)
+			return
+		}
+	}
+}
+
+// Handles a message of the server, the lines that are not JSON-RPC messages
+// are ignored
+func (client *Client) handle(line []byte) {
+	var msg message
+	if err := json.Unmarshal(line, &msg); err != nil {
+		return
+	}
+
+	switch {
+	case msg.Method != "" && len(msg.ID) > 0:
+		client.answer(msg)
+	case msg.Method != "":
+		// the notifications (logs, progress...) are not used
+	default:
+		var id int64
+		if err := json.Unmarshal(msg.ID, &id); err != nil {
+			return
+		}
+
+		client.mu.Lock()
+		responses, ok := client.pending[id]
+		client.mu.Unlock()
+
+		// a duplicated response must not block the loop
+		if ok {
+			select {
+			case responses <- msg:
+			default:
+			}
+		}
+	}
+}
+
+// Answers a request of the server, gollama declares no capabilities so only
+// pings are supported
+func (client *Client) answer(msg message) {
+	reply := response{JSONRPC: "2.0", ID: msg.ID}
+
+	if msg.Method == "ping" {
+		reply.Result = map[string]any{}
+	} else {
+		reply.Error = &RPCError{Code: codeMethodNotFound, Message: "method not found: " + msg.Method}
+	}
+
+	_ = client.write(reply)
+}
+
+// Done returns a channel that is closed once the server exited (or closed its
+// stdout)
+func (client *Client) Done() <-chan struct{} {
+	return client.done
+}
+
+// Err returns why the server exited, it is only set once Done is closed
+func (client *Client) Err() error {
+	select {
+	case <-client.done:
+		return client.err
+	default:
+		return nil
+	}
+}
+
+// Stops the server, it is killed if it doesn't exit once its stdin is closed
+func (client *Client) Close() {
+	_ = client.stdin.Close()
+
+	select {
+	case <-client.done:
+		return
+	case <-time.After(stopTimeout):
+	}
+
+	// the processes started by the server could keep its stdout open
+	_ = client.cmd.Process.Kill()
+	select {
+	case <-client.done:
+	case <-time.After(stopTimeout):
+	}
+}
+
+// Adds the last line the server wrote to stderr to the error
+func (client *Client) withStderr(err error) error {
+	if line := client.stderr.lastLine(); line != "" {
+		return fmt.Errorf("%w: %s", err, line)
+	}
+	return err
+}
+
+// Keeps the end of what is written to it
+type tailBuffer struct {
+	mu   sync.Mutex
+	data []byte
+}
+
+func (buffer *tailBuffer) Write(p []byte) (int, error) {
+	buffer.mu.Lock()
+	defer buffer.mu.Unlock()
+
+	buffer.data = append(buffer.data, p...)
+	if len(buffer.data) > maxStderr {
+		buffer.data = buffer.data[len(buffer.data)-maxStderr:]
+	}
+
+	return len(p), nil
+}
+
+// Returns the last non-empty line written to the buffer
+func (buffer *tailBuffer) lastLine() string {
+	buffer.mu.Lock()
+	defer buffer.mu.Unlock()
+
+	lines := strings.Split(strings.TrimSpace(string(buffer.data)), "\n")
+	return strings.TrimSpace(lines[len(lines)-1])
+}
@@ -0,0 +1,191 @@
+package mcp
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+
+	"github.com/gaurav-gosain/gollama/internal/tools"
+)
+
+// A tool of a server, as listed by tools/list
+type Tool struct {
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	InputSchema Schema `json:"inputSchema"`
+}
+
+// The JSON schema of a tool's arguments, only the top level properties are
+// described to the models
+type Schema struct {
+	Properties map[string]Property `json:"properties"`
+	Required   []string            `json:"required"`
+}
+
+// A property of a tool's arguments
+type Property struct {
+	Type        json.RawMessage `json:"type"` // a type or a list of types
+	Description string          `json:"description"`
+	Enum        []any           `json:"enum"`
+}
+
+// The content of a tools/call result
+type content struct {
+	Type     string `json:"type"`
+	Text     string `json:"text"`
+	MimeType string `json:"mimeType"`
+	Resource struct {
+		URI  string `json:"uri"`
+		Text string `json:"text"`
+	} `json:"resource"`
+}
+
+// Returns the tools of the server, the pages of the list are all fetched
+func (client *Client) ListTools(ctx context.Context) ([]Tool, error) {
+	serverTools := []Tool{}
+	cursor := ""
+
+	for {
+		params := map[string]any{}
+		if cursor != "" {
+			params["cursor"] = cursor
+		}
+
+		var result struct {
+			Tools      []Tool `json:"tools"`
+			NextCursor string `json:"nextCursor"`
+		}
+		if err := client.call(ctx, "tools/list", params, &result); err != nil {
+			return nil, err
+		}
+
+		serverTools = append(serverTools, result.Tools...)
+		if result.NextCursor == "" {
+			return serverTools, nil
+		}
+		cursor = result.NextCursor
+	}
+}
+
+// Calls the tool of the server, the text contents of the result are returned
+// (an error if the server reports the call as failed)
+func (client *Client) CallTool(ctx context.Context, name string, args map[string]any) (string, error) {
+	var result struct {
+		Content []content `json:"content"`
+		IsError bool      `json:"isError"`
+	}
+
+	err := client.call(ctx, "tools/call", map[string]any{
+		"name":      name,
+		"arguments": args,
+	}, &result)
+	if err != nil {
+		return "", err
+	}
+
+	parts := []string{}
+	for _, c := range result.Content {
+		switch {
+		case c.Type == "text":
+			parts = append(parts, c.Text)
+		case c.Type == "resource" && c.Resource.Text != "":
+			parts = append(parts, c.Resource.Text)
+		case c.Type == "resource":
+			parts = append(parts, fmt.Sprintf("(resource %s)", c.Resource.URI))
+		default:
+			// the models can't read images or audio from the tool outputs
+			parts = append(parts, fmt.Sprintf("(%s content, %s)", c.Type, c.MimeType))
+		}
+	}
+	output := strings.Join(parts, "\n")
+
+	if result.IsError {
+		if output == "" {
+			output = "the tool failed"
+		}
+		return "", errors.New(output)
+	}
+
+	return output, nil
+}
+
+// Returns the name the tool of the server is registered under, the servers
+// could have tools with the same name
+func ToolName(server, tool string) string {
+	return server + "__" + tool
+}
+
+// Registers the tools of the server in the tools registry, the calls are
+// forwarded to the server, returns the registered names
+func RegisterTools(client *Client, serverTools []Tool, approve bool) []string {
+	names := []string{}
+
+	for _, serverTool := range serverTools {
+		name := serverTool.Name
+
+		tool := tools.Tool{
+			Name:          ToolName(client.Name, name),
+			Description:   serverTool.Description,
+			Server:        client.Name,
+			NeedsApproval: approve,
+			Run: func(ctx context.Context, args tools.Args) (string, error) {
+				return client.CallTool(ctx, name, args)
+			},
+		}
+
+		schema := serverTool.InputSchema
+		for _, property := range slices.Sorted(maps.Keys(schema.Properties)) {
+			tool.Params = append(tool.Params, newParam(property, schema))
+		}
+
+		tools.Register(tool)
+		names = append(names, tool.Name)
+	}
+
+	return names
+}
+
+// Removes the tools from the tools registry
+func UnregisterTools(names []string) {
+	for _, name := range names {
+		tools.Unregister(name)
+	}
+}
+
+// Converts the property of the schema to a tool parameter
+func newParam(name string, schema Schema) tools.Param {
+	property := schema.Properties[name]
+
+	param := tools.Param{
+		Name:        name,
+		Type:        "string",
+		Description: property.Description,
+		Required:    slices.Contains(schema.Required, name),
+	}
+
+	// the first type of a list that isn't null, e.g. ["string", "null"]
+	var types []string
+	if err := json.Unmarshal(property.Type, &param.Type); err != nil {
+		if err := json.Unmarshal(property.Type, &types); err == nil {
+			for _, t := range types {
+				if t != "null" {
+					param.Type = t
+					break
+				}
+			}
+		}
+	}
+	if param.Type == "" {
+		param.Type = "string"
+	}
+
+	for _, value := range property.Enum {
+		param.Enum = append(param.Enum, fmt.Sprint(value))
+	}
+
+	return param
+}
@@ -6,4 +6,5 @@ const (
 	SYSTEM    string = "system"
 	USER      string = "user"
 	ASSISTANT string = "assistant"
+	TOOL      string = "tool" // the output of a tool called by the assistant
 )
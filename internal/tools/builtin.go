@@ -0,0 +1,351 @@
+package tools
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// the number of bytes read_file reads
+	maxReadSize = 64 * 1024
+	// the files larger than this are skipped by grep
+	maxGrepFileSize = 1024 * 1024
+	// the number of matching lines grep returns
+	maxGrepMatches = 200
+	// the number of entries list_directory returns
+	maxDirectoryEntries = 500
+	// the time the shell command can run before it is killed
+	shellTimeout = time.Minute
+)
+
+// the names of the built-in tools
+const (
+	ReadFile      = "read_file"
+	ListDirectory = "list_directory"
+	Grep          = "grep"
+	Calculator    = "calculator"
+	CurrentTime   = "current_time"
+	Shell         = "shell"
+)
+
+func init() {
+	Register(Tool{
+		Name:        ReadFile,
+		Description: "Read a text file in the working directory",
+		Params: []Param{
+			{Name: "path", Type: "string", Description: "The path of the file, relative to the working directory", Required: true},
+		},
+		Run: readFile,
+	})
+	Register(Tool{
+		Name:        ListDirectory,
+		Description: "List the files and directories of a directory in the working directory",
+		Params: []Param{
+			{Name: "path", Type: "string", Description: "The path of the directory, relative to the working directory (defaults to the working directory)"},
+		},
+		Run: listDirectory,
+	})
+	Register(Tool{
+		Name:        Grep,
+		Description: "Search the text files of the working directory for the lines matching a regular expression",
+		Params: []Param{
+			{Name: "pattern", Type: "string", Description: "The regular expression (RE2 syntax)", Required: true},
+			{Name: "path", Type: "string", Description: "The file or directory to search, relative to the working directory (defaults to the working directory)"},
+		},
+		Run: grep,
+	})
+	Register(Tool{
+		Name:        Calculator,
+		Description: "Evaluate an arithmetic expression with + - * / %, parentheses, pow(x, y), sqrt, abs, floor, ceil, round, exp, ln, log, log2, sin, cos, tan, asin, acos, atan, min, max, pi and e",
+		Params: []Param{
+			{Name: "expression", Type: "string", Description: "The expression, e.g. 2 * pow(3 + 4, 2) / sqrt(16)", Required: true},
+		},
+		Run: calculator,
+	})
+	Register(Tool{
+		Name:        CurrentTime,
+		Description: "Get the current date and time",
+		Params: []Param{
+			{Name: "timezone", Type: "string", Description: "The IANA time zone, e.g. Europe/Paris (defaults to the local time zone)"},
+		},
+		Run: currentTime,
+	})
+	Register(Tool{
+		Name:          Shell,
+		Description:   "Run a shell command (with sh) in the working directory, the user has to approve every command",
+		Params:        []Param{{Name: "command", Type: "string", Description: "The command to run", Required: true}},
+		NeedsApproval: true,
+		Run:           shell,
+	})
+}
+
+// Returns the path argument resolved in the working directory, the paths
+// outside of it are rejected
+func workingPath(args Args, name string) (string, error) {
+	path, err := args.String(name, ".")
+	if err != nil {
+		return "", err
+	}
+
+	root, err := os.Getwd()
+	if err != nil {
+		return "", err
+	}
+
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(root, path)
+	}
+	path = filepath.Clean(path)
+
+	// the symbolic links could point outside of the working directory
+	if resolved, err := filepath.EvalSymlinks(path); err == nil {
+		path = resolved
+	}
+	if resolved, err := filepath.EvalSymlinks(root); err == nil {
+		root = resolved
+	}
+
+	relative, err := filepath.Rel(root, path)
+	if err != nil || relative == ".." || strings.HasPrefix(relative, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("%s is outside of the working directory", path)
+	}
+
+	return path, nil
+}
+
+// Returns true if the data looks like the content of a binary file
+func isBinary(data []byte) bool {
+	return bytes.IndexByte(data, 0) >= 0
+}
+
+func readFile(_ context.Context, args Args) (string, error) {
+	path, err := workingPath(args, "path")
+	if err != nil {
+		return "", err
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close() //nolint:errcheck
+
+	info, err := file.Stat()
+	if err != nil {
+		return "", err
+	}
+	if info.IsDir() {
+		return "", fmt.Errorf("%s is a directory", path)
+	}
+
+	data, err := io.ReadAll(io.LimitReader(file, maxReadSize))
+	if err != nil {
+		return "", err
+	}
+	if isBinary(data) {
+		return "", fmt.Errorf("%s is not a text file", path)
+	}
+
+	content := strings.ToValidUTF8(string(data), "")
+	if info.Size() > maxReadSize {
+		content += fmt.Sprintf("\n(truncated, only the first %d of %d bytes are included)", maxReadSize, info.Size())
+	}
+
+	return content, nil
+}
+
+func listDirectory(_ context.Context, args Args) (string, error) {
+	path, err := workingPath(args, "path")
+	if err != nil {
+		return "", err
+	}
+
+	entries, err := os.ReadDir(path)
+	if err != nil {
+		return "", err
+	}
+	if len(entries) == 0 {
+		return "(empty directory)", nil
+	}
+
+	var b strings.Builder
+	for i, entry := range entries {
+		if i == maxDirectoryEntries {
+			fmt.Fprintf(&b, "(%d more entries)\n", len(entries)-maxDirectoryEntries)
+			break
+		}
+
+		switch info, err := entry.Info(); {
+		case entry.IsDir():
+			fmt.Fprintf(&b, "%s/\n", entry.Name())
+		case err == nil:
+			fmt.Fprintf(&b, "%s (%d bytes)\n", entry.Name(), info.Size())
+		default:
+			fmt.Fprintf(&b, "%s\n", entry.Name())
+		}
+	}
+
+	return b.String(), nil
+}
+
+func grep(ctx context.Context, args Args) (string, error) {
+	pattern, err := args.String("pattern", "")
+	if err != nil {
+		return "", err
+	}
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return "", fmt.Errorf("invalid pattern: %w", err)
+	}
+
+	root, err := workingPath(args, "path")
+	if err != nil {
+		return "", err
+	}
+	cwd, _ := os.Getwd()
+
+	matches := []string{}
+	errFull := errors.New("too many matches")
+
+	err = filepath.WalkDir(root, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			// the unreadable files and directories are skipped
+			return nil
+		}
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return ctxErr
+		}
+
+		if entry.IsDir() {
+			if path != root && strings.HasPrefix(entry.Name(), ".") {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		if info, err := entry.Info(); err != nil || !info.Mode().IsRegular() || info.Size() > maxGrepFileSize {
+			return nil
+		}
+
+		data, err := os.ReadFile(path)
+		if err != nil || isBinary(data) {
+			return nil
+		}
+
+		name := path
+		if relative, err := filepath.Rel(cwd, path); err == nil {
+			name = relative
+		}
+
+		scanner := bufio.NewScanner(bytes.NewReader(data))
+		scanner.Buffer(make([]byte, 0, 64*1024), maxGrepFileSize) //nolint:mnd
+		for line := 1; scanner.Scan(); line++ {
+			if !re.MatchString(scanner.Text()) {
+				continue
+			}
+
+			matches = append(matches, fmt.Sprintf("%s:%d: %s", name, line, scanner.Text()))
+			if len(matches) == maxGrepMatches {
+				return errFull
+			}
+		}
+
+		return nil
+	})
+
+	if err != nil && !errors.Is(err, errFull) {
+		return "", err
+	}
+	if len(matches) == 0 {
+		return "No matches", nil
+	}
+
+	output := strings.Join(matches, "\n")
+	if errors.Is(err, errFull) {
+		output += fmt.Sprintf("\n(stopped after %d matches)", maxGrepMatches)
+	}
+
+	return output, nil
+}
+
+func calculator(_ context.Context, args Args) (string, error) {
+	expression, err := args.String("expression", "")
+	if err != nil {
+		return "", err
+	}
+
+	result, err := Calculate(expression)
+	if err != nil {
+		return "", err
+	}
+
+	return strconv.FormatFloat(result, 'g', -1, 64), nil
+}
+
+func currentTime(_ context.Context, args Args) (string, error) {
+	timezone, err := args.String("timezone", "")
+	if err != nil {
+		return "", err
+	}
+
+	now := time.Now()
+	if timezone != "" {
+		location, err := time.LoadLocation(timezone)
+		if err != nil {
+			return "", fmt.Errorf("unknown time zone %q", timezone)
+		}
+		now = now.In(location)
+	}
+
+	return now.Format("Monday, 02 January 2006 15:04:05 MST (-07:00)"), nil
+}
+
+func shell(ctx context.Context, args Args) (string, error) {
+	command, err := args.String("command", "")
+	if err != nil {
+		return "", err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, shellTimeout)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, "sh", "-c", command) //nolint:gosec
+	// the output pipes of processes started by the command could keep Wait
+	// from returning once it is killed
+	cmd.WaitDelay = time.Second
+
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	err = cmd.Run()
+
+	var exitErr *exec.ExitError
+	switch {
+	case errors.Is(ctx.Err(), context.DeadlineExceeded):
+		return "", fmt.Errorf("the command was killed after %s", shellTimeout)
+	case ctx.Err() != nil:
+		return "", ctx.Err()
+	case err != nil && !errors.As(err, &exitErr):
+		return "", err
+	}
+
+	return fmt.Sprintf(
+		"exit code: %d\n\nstdout:\n%s\n\nstderr:\n%s",
+		cmd.ProcessState.ExitCode(),
+		strings.TrimRight(stdout.String(), "\n"),
+		strings.TrimRight(stderr.String(), "\n"),
+	), nil
+}
@@ -0,0 +1,162 @@
+package tools
+
+import (
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"math"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// the functions and constants that can be used in the calculator's
+// expressions
+var (
+	calcFunctions = map[string]func(...float64) (float64, error){
+		"sqrt":  unary(math.Sqrt),
+		"abs":   unary(math.Abs),
+		"floor": unary(math.Floor),
+		"ceil":  unary(math.Ceil),
+		"round": unary(math.Round),
+		"exp":   unary(math.Exp),
+		"ln":    unary(math.Log),
+		"log":   unary(math.Log10),
+		"log2":  unary(math.Log2),
+		"sin":   unary(math.Sin),
+		"cos":   unary(math.Cos),
+		"tan":   unary(math.Tan),
+		"asin":  unary(math.Asin),
+		"acos":  unary(math.Acos),
+		"atan":  unary(math.Atan),
+		"pow": func(args ...float64) (float64, error) {
+			if len(args) != 2 { //nolint:mnd
+				return 0, fmt.Errorf("pow takes 2 arguments")
+			}
+			return math.Pow(args[0], args[1]), nil
+		},
+		"min": func(args ...float64) (float64, error) {
+			if len(args) == 0 {
+				return 0, fmt.Errorf("min takes at least 1 argument")
+			}
+			return slices.Min(args), nil
+		},
+		"max": func(args ...float64) (float64, error) {
+			if len(args) == 0 {
+				return 0, fmt.Errorf("max takes at least 1 argument")
+			}
+			return slices.Max(args), nil
+		},
+	}
+	calcConstants = map[string]float64{
+		"pi": math.Pi,
+		"e":  math.E,
+	}
+)
+
+// Evaluates an arithmetic expression, e.g. "2 * pow(3 + 4, 2) / sqrt(16)",
+// the expression is parsed as a Go expression (^ is not a power operator)
+func Calculate(expression string) (float64, error) {
+	expr, err := parser.ParseExpr(expression)
+	if err != nil {
+		return 0, fmt.Errorf("invalid expression: %w", err)
+	}
+
+	return evaluate(expr)
+}
+
+// Evaluates the node of the parsed expression
+func evaluate(node ast.Expr) (float64, error) {
+	switch n := node.(type) {
+	case *ast.BasicLit:
+		if n.Kind != token.INT && n.Kind != token.FLOAT {
+			return 0, fmt.Errorf("unexpected %s", n.Value)
+		}
+		return strconv.ParseFloat(n.Value, 64)
+	case *ast.Ident:
+		if value, ok := calcConstants[strings.ToLower(n.Name)]; ok {
+			return value, nil
+		}
+		return 0, fmt.Errorf("unknown constant %q", n.Name)
+	case *ast.ParenExpr:
+		return evaluate(n.X)
+	case *ast.UnaryExpr:
+		x, err := evaluate(n.X)
+		if err != nil {
+			return 0, err
+		}
+		switch n.Op {
+		case token.SUB:
+			return -x, nil
+		case token.ADD:
+			return x, nil
+		}
+	case *ast.BinaryExpr:
+		return evaluateBinary(n)
+	case *ast.CallExpr:
+		name, ok := n.Fun.(*ast.Ident)
+		if !ok {
+			return 0, fmt.Errorf("unexpected function call")
+		}
+		function, ok := calcFunctions[strings.ToLower(name.Name)]
+		if !ok {
+			return 0, fmt.Errorf("unknown function %q", name.Name)
+		}
+
+		args := make([]float64, len(n.Args))
+		for i, arg := range n.Args {
+			value, err := evaluate(arg)
+			if err != nil {
+				return 0, err
+			}
+			args[i] = value
+		}
+		return function(args...)
+	}
+
+	return 0, fmt.Errorf("unsupported expression")
+}
+
+// Evaluates the binary operation
+func evaluateBinary(n *ast.BinaryExpr) (float64, error) {
+	x, err := evaluate(n.X)
+	if err != nil {
+		return 0, err
+	}
+	y, err := evaluate(n.Y)
+	if err != nil {
+		return 0, err
+	}
+
+	switch n.Op {
+	case token.ADD:
+		return x + y, nil
+	case token.SUB:
+		return x - y, nil
+	case token.MUL:
+		return x * y, nil
+	case token.QUO:
+		if y == 0 {
+			return 0, fmt.Errorf("division by zero")
+		}
+		return x / y, nil
+	case token.REM:
+		if y == 0 {
+			return 0, fmt.Errorf("division by zero")
+		}
+		return math.Mod(x, y), nil
+	}
+
+	return 0, fmt.Errorf("unsupported operator %s", n.Op)
+}
+
+// Wraps a single argument math function
+func unary(function func(float64) float64) func(...float64) (float64, error) {
+	return func(args ...float64) (float64, error) {
+		if len(args) != 1 {
+			return 0, fmt.Errorf("expected 1 argument, got %d", len(args))
+		}
+		return function(args[0]), nil
+	}
+}
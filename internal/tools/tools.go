@@ -0,0 +1,210 @@
+package tools
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+	"sync"
+
+	oapi "github.com/ollama/ollama/api"
+)
+
+// the number of bytes of a tool's output sent to the model, the rest is cut
+const maxOutput = 16 * 1024
+
+// A parameter of a tool, described to the model as a JSON schema property
+type Param struct {
+	Name        string
+	Type        string // "string", "integer", "number" or "boolean"
+	Description string
+	Required    bool
+	Enum        []string
+}
+
+// A tool the models can call, Run returns the output sent back to the model
+type Tool struct {
+	Name        string
+	Description string
+	Params      []Param
+	// the MCP server providing the tool, empty for the built-in tools
+	Server string
+	// the calls have to be approved by the user before they run
+	NeedsApproval bool
+	Run           func(ctx context.Context, args Args) (string, error)
+}
+
+// The arguments of a tool call, as decoded from the model's JSON
+type Args map[string]any
+
+var (
+	// the registered tools by name
+	registry   = map[string]Tool{}
+	registryMu sync.RWMutex
+)
+
+// Register adds the tool to the registry, a tool with the same name is
+// replaced
+func Register(tool Tool) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+
+	registry[tool.Name] = tool
+}
+
+// Unregister removes the tool from the registry
+func Unregister(name string) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+
+	delete(registry, name)
+}
+
+// Get returns the registered tool with the provided name
+func Get(name string) (Tool, bool) {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+
+	tool, ok := registry[name]
+	return tool, ok
+}
+
+// Names returns the names of the registered tools in order
+func Names() []string {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+
+	return slices.Sorted(maps.Keys(registry))
+}
+
+// Builtin returns the names of the built-in tools in order
+func Builtin() []string {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+
+	names := []string{}
+	for name, tool := range registry {
+		if tool.Server == "" {
+			names = append(names, name)
+		}
+	}
+	slices.Sort(names)
+
+	return names
+}
+
+// Definitions returns the definitions of the named tools sent with a chat
+// request, the names that are not registered are skipped
+func Definitions(names []string) oapi.Tools {
+	definitions := oapi.Tools{}
+	for _, name := range names {
+		if tool, ok := Get(name); ok {
+			definitions = append(definitions, tool.Definition())
+		}
+	}
+
+	return definitions
+}
+
+// Definition returns the definition of the tool in the format of the Ollama
+// API
+func (tool Tool) Definition() oapi.Tool {
+	function := oapi.ToolFunction{
+		Name:        tool.Name,
+		Description: tool.Description,
+	}
+
+	function.Parameters.Type = "object"
+	function.Parameters.Required = []string{}
+	function.Parameters.Properties = map[string]struct {
+		Type        string   `json:"type"`
+		Description string   `json:"description"`
+		Enum        []string `json:"enum,omitempty"`
+	}{}
+
+	for _, param := range tool.Params {
+		property := function.Parameters.Properties[param.Name]
+		property.Type = param.Type
+		property.Description = param.Description
+		property.Enum = param.Enum
+		function.Parameters.Properties[param.Name] = property
+
+		if param.Required {
+			function.Parameters.Required = append(function.Parameters.Required, param.Name)
+		}
+	}
+
+	return oapi.Tool{
+		Type:     "function",
+		Function: function,
+	}
+}
+
+// Call runs the named tool with the arguments, the output is truncated to
+// maxOutput bytes
+func Call(ctx context.Context, name string, args Args) (string, error) {
+	tool, ok := Get(name)
+	if !ok {
+		return "", fmt.Errorf("unknown tool %q", name)
+	}
+
+	for _, param := range tool.Params {
+		if _, ok := args[param.Name]; param.Required && !ok {
+			return "", fmt.Errorf("missing argument %q", param.Name)
+		}
+	}
+
+	output, err := tool.Run(ctx, args)
+	if err != nil {
+		return "", err
+	}
+
+	return truncate(output), nil
+}
+
+// Returns the string argument, def if it is not set
+func (args Args) String(name, def string) (string, error) {
+	value, ok := args[name]
+	if !ok || value == nil {
+		return def, nil
+	}
+
+	s, ok := value.(string)
+	if !ok {
+		return "", fmt.Errorf("argument %q must be a string", name)
+	}
+
+	return s, nil
+}
+
+// Returns the integer argument, def if it is not set (some models send
+// numbers as strings)
+func (args Args) Int(name string, def int) (int, error) {
+	value, ok := args[name]
+	if !ok || value == nil {
+		return def, nil
+	}
+
+	switch v := value.(type) {
+	case float64:
+		return int(v), nil
+	case string:
+		var n int
+		if _, err := fmt.Sscanf(strings.TrimSpace(v), "%d", &n); err == nil {
+			return n, nil
+		}
+	}
+
+	return 0, fmt.Errorf("argument %q must be an integer", name)
+}
+
+// Keeps the first maxOutput bytes of the output
+func truncate(output string) string {
+	if len(output) <= maxOutput {
+		return output
+	}
+
+	return strings.ToValidUTF8(output[:maxOutput], "") +
+		fmt.Sprintf("\n(truncated, only the first %d of %d bytes are included)", maxOutput, len(output))
+}
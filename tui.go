@@ -3,9 +3,7 @@ package main
 import (
 	"fmt"
 	"os"
-	"path/filepath"
 
-	"github.com/adrg/xdg"
 	"github.com/charmbracelet/bubbles/list"
 	tea "github.com/charmbracelet/bubbletea"
 	"github.com/charmbracelet/huh"
@@ -14,6 +12,7 @@ import (
 	"github.com/gaurav-gosain/gollama/internal/chat"
 	"github.com/gaurav-gosain/gollama/internal/chatpicker"
 	"github.com/gaurav-gosain/gollama/internal/client"
+	"github.com/gaurav-gosain/gollama/internal/config"
 	"github.com/gaurav-gosain/gollama/internal/utils"
 	zone "github.com/lrstanley/bubblezone"
 )
@@ -27,6 +26,26 @@ func tui() {
 
 	defer client.GollamaInstance.DB.Close()
 
+	// the theme is loaded before the TUI starts, the terminal can't be asked
+	// for its background color once it is running
+	if err := config.Load(); err != nil {
+		utils.PrintError(err, false)
+	}
+	if theme, err := config.LoadTheme(config.Current.Theme); err != nil {
+		utils.PrintError(err, false)
+	} else {
+		chat.SetTheme(theme)
+	}
+
+	// the conflicting keybindings are reported before the TUI takes over the
+	// screen, the default keys are used instead
+	if err := chat.ApplyKeys(config.Current.Keys.Chat); err != nil {
+		utils.PrintError(err, false)
+	}
+	if err := chatpicker.ApplyKeys(config.Current.Keys.Picker); err != nil {
+		utils.PrintError(err, false)
+	}
+
 	// keeps the TUI running until the user explicitly exits (or an error occurs)
 	for {
 		chats, err := client.GollamaInstance.ListChats()
@@ -78,12 +97,7 @@ func tui() {
 
 				if deleteChat {
 					// remove chat from disk
-					if err := os.Remove(filepath.Join(
-						xdg.DataHome,
-						"gollama",
-						"chats",
-						chatPicker.ID+".gob",
-					)); err != nil {
+					if err := os.Remove(chat.HistoryPath(chatPicker.ID)); err != nil {
 						utils.PrintError(err, true)
 					}
 
@@ -102,6 +116,10 @@ func tui() {
 						),
 					)
 				}
+			case chatpicker.ExitReasonEditChat:
+				if _, err := chat.EditChatSettingsForm(chatPicker); err != nil {
+					utils.PrintError(err, true)
+				}
 			case chatpicker.ExitReasonSelect:
 				chatSettings = chatPicker
 			case chatpicker.ExitReasonNewChat:
@@ -148,24 +166,11 @@ func tui() {
 			}
 
 			gollamaChat = m.(*chat.Chat)
+			gollamaChat.Close()
 
 			// save the chat history to a .gob file if the chat is not anonymous
-			if !gollamaChat.ChatSettings.IsAnonymous {
-				// dump chat history to .gob file
-				file, err := os.Create(filepath.Join(
-					xdg.DataHome,
-					"gollama",
-					"chats",
-					gollamaChat.ChatSettings.ID+".gob",
-				))
-				if err != nil {
-					utils.PrintError(err, true)
-				}
-				defer file.Close() //nolint:errcheck
-
-				if err := chat.EncodeGob(file, &gollamaChat.ChatHistory); err != nil {
-					utils.PrintError(err, true)
-				}
+			if err := gollamaChat.SaveHistory(); err != nil {
+				utils.PrintError(err, true)
 			}
 		}
 